@@ -1,41 +1,70 @@
 package main
 
 import (
+	"HttpFromTcp/internal/middleware"
 	"HttpFromTcp/internal/request"
 	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/router"
 	"HttpFromTcp/internal/server"
-	"bytes"
 	"fmt"
 	"log"
 )
 
-// appHandler contains our specific routing and business logic.
-func appHandler(w *bytes.Buffer, req *request.Request) *server.HandlerError {
-	// Route based on the request target (path).
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		// Return a 400 Bad Request error.
-		return &server.HandlerError{
-			StatusCode: response.StatusBadRequest,
-			Message:    "Your problem is not my problem\n",
-		}
-	case "/myproblem":
-		// Return a 500 Internal Server Error.
-		return &server.HandlerError{
-			StatusCode: response.StatusInternalServerError,
-			Message:    "Woopsie, my bad\n",
-		}
-	default:
-		// For all other paths, write a success message to the response body.
-		fmt.Fprint(w, "All good, frfr\n")
-		// Return nil to indicate success.
-		return nil
+// yourProblem returns a 400 Bad Request error.
+func yourProblem(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+	return &server.HandlerError{
+		StatusCode: response.StatusBadRequest,
+		Message:    "Your problem is not my problem\n",
 	}
 }
 
+// myProblem returns a 500 Internal Server Error.
+func myProblem(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+	return &server.HandlerError{
+		StatusCode: response.StatusInternalServerError,
+		Message:    "Woopsie, my bad\n",
+	}
+}
+
+// allGood writes a success message to the response body.
+func allGood(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+	fmt.Fprint(w, "All good, frfr\n")
+	// Return nil to indicate success.
+	return nil
+}
+
+// coffeeOrder is the JSON body posted to /coffee (see rawpost.http).
+type coffeeOrder struct {
+	Flavor string `json:"flavor"`
+}
+
+// brewCoffee accepts a JSON coffee order and echoes it back.
+func brewCoffee(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+	var order coffeeOrder
+	if herr := server.DecodeJSON(req, &order, 0); herr != nil {
+		return herr
+	}
+	if err := server.WriteJSON(w, response.StatusCreated, map[string]string{"brewing": order.Flavor}); err != nil {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	return nil
+}
+
+// newRouter contains our specific routing and business logic.
+func newRouter() *router.Router {
+	r := router.New()
+	r.Use(middleware.RequestID, middleware.Logging, middleware.Recover, middleware.Timing)
+	r.GET("/yourproblem", yourProblem)
+	r.GET("/myproblem", myProblem)
+	r.POST("/coffee", brewCoffee)
+	// For all other paths, answer with the success message.
+	r.GET("/*path", allGood)
+	return r
+}
+
 func main() {
-	// Pass our application handler to the server.
-	s, err := server.Serve(42069, appHandler)
+	// Pass our application router to the server.
+	s, err := server.Serve(42069, newRouter().ServeRequest)
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -43,4 +72,4 @@ func main() {
 
 	// Keep the server running until manually stopped.
 	select {}
-}
\ No newline at end of file
+}
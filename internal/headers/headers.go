@@ -3,20 +3,175 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"iter"
+	"net/textproto"
 	"strings"
 )
 
-// Headers is a map to store header key-value pairs.
-type Headers map[string]string
+// ParseError is an error found in a header or trailer field line. Status is
+// the HTTP status code a server should answer the request with. The request
+// package reports its own parse errors with this type too.
+type ParseError struct {
+	Status  int
+	Message string
+}
+
+// Error makes ParseError satisfy the standard error interface.
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// Errors returned by Parse. They are wrapped with details about the
+// offending line, so compare them with errors.Is.
+var (
+	ErrMalformedFieldLine = &ParseError{Status: 400, Message: "invalid header line"}
+	ErrSpaceBeforeColon   = &ParseError{Status: 400, Message: "invalid header line: space before colon"}
+	ErrInvalidFieldName   = &ParseError{Status: 400, Message: "invalid character in header key"}
+	ErrInvalidFieldValue  = &ParseError{Status: 400, Message: "invalid character in header value"}
+	ErrBareLF             = &ParseError{Status: 400, Message: "invalid header line: LF without a preceding CR"}
+	ErrObsFold            = &ParseError{Status: 400, Message: "invalid header line: obsolete line folding"}
+)
+
+// Headers is an ordered list of header fields. A name may appear more than
+// once (e.g. Set-Cookie), and every occurrence is kept as its own entry, in
+// the order it was parsed or added. Names are matched case-insensitively.
+type Headers struct {
+	fields []field
+}
+
+// field is a single "name: value" line.
+type field struct {
+	name  string
+	value string
+}
+
+// NewHeaders creates and returns an empty Headers. The methods that only
+// read (Get, Values, Has, Len, All, Names, Clone) also work on a nil
+// *Headers, which acts as an empty one.
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+// Get returns the value of the named field. If the field appears more than
+// once, the values are joined with "," in the order they appeared, which is
+// how a recipient may combine them (RFC 9110, Section 5.3). Fields such as
+// Set-Cookie that can't be combined should be read with Values.
+func (h *Headers) Get(key string) string {
+	values := h.Values(key)
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values, ",")
+}
+
+// Values returns every value of the named field, in order.
+func (h *Headers) Values(key string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Has reports whether the named field is present, even with an empty value.
+func (h *Headers) Has(key string) bool {
+	if h == nil {
+		return false
+	}
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a field, keeping any existing values for the same name.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{name: key, value: value})
+}
+
+// Set stores a header value, replacing any existing values for the key.
+// The field keeps the position of its first occurrence.
+func (h *Headers) Set(key, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			h.fields[i] = field{name: key, value: value}
+			h.delFrom(key, i+1)
+			return
+		}
+	}
+	h.Add(key, value)
+}
 
-// NewHeaders creates and returns an initialized Headers map.
-func NewHeaders() Headers {
-	return make(Headers)
+// Del removes every value of the named field.
+func (h *Headers) Del(key string) {
+	h.delFrom(key, 0)
 }
-// get performs a case-insensitive key lookup
 
-func (h Headers) Get(key string) string{
-	return h[strings.ToLower(key)]
+// delFrom removes the named field from index start onwards.
+func (h *Headers) delFrom(key string, start int) {
+	kept := h.fields[:start]
+	for _, f := range h.fields[start:] {
+		if !strings.EqualFold(f.name, key) {
+			kept = append(kept, f)
+		}
+	}
+	h.fields = kept
+}
+
+// Len returns the number of fields, counting repeated names separately.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// All iterates over the fields in order, one (name, value) pair per field,
+// with names in canonical form ("content-type" becomes "Content-Type").
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(textproto.CanonicalMIMEHeaderKey(f.name), f.value) {
+				return
+			}
+		}
+	}
+}
+
+// Names returns the distinct field names in canonical form, in the order
+// each first appeared.
+func (h *Headers) Names() []string {
+	if h == nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]bool, len(h.fields))
+	for name := range h.All() {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Clone returns a copy of h that can be changed independently. The clone
+// of a nil *Headers is a new, empty Headers.
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return NewHeaders()
+	}
+	return &Headers{fields: append([]field(nil), h.fields...)}
 }
 
 // isValidTchar checks if a byte is a valid "tchar" as defined by RFC 9110.
@@ -38,8 +193,42 @@ func isValidTchar(b byte) bool {
 	}
 }
 
+// ValidFieldName reports whether name is a valid field name: a non-empty
+// token made only of tchars, as Parse requires of incoming fields.
+func ValidFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isValidTchar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidFieldValue reports whether value can be sent as a field value. CR,
+// LF and NUL are never allowed (RFC 9110, Section 5.5): a CRLF smuggled
+// into a value would end the field early and let the rest of the value
+// inject headers or a whole response.
+func ValidFieldValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
 // Parse parses a single header line from a byte slice.
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// Each call consumes at most one line and appends it to h.
+//
+// Parsing is strict about anything two parsers could read differently,
+// since that is what request smuggling feeds on (RFC 9112, Sections 2.2
+// and 5): lines must end in CRLF, there may be no whitespace before the
+// colon, and a line starting with whitespace (obs-fold) is rejected rather
+// than joined to the previous field.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	// A LF before the first CRLF is a bare line ending. Some parsers accept
+	// it as the end of the line and some don't, so refuse it outright.
+	if lf := bytes.IndexByte(data, '\n'); lf != -1 && (lf == 0 || data[lf-1] != '\r') {
+		return 0, false, ErrBareLF
+	}
 	crlfIndex := bytes.Index(data, []byte("\r\n"))
 	if crlfIndex == -1 {
 		return 0, false, nil
@@ -52,40 +241,42 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	lineBytes := data[:crlfIndex]
 	bytesConsumed := crlfIndex + 2
 
+	if lineBytes[0] == ' ' || lineBytes[0] == '\t' {
+		return 0, false, ErrObsFold
+	}
+
 	colonIndex := bytes.Index(lineBytes, []byte(":"))
 	if colonIndex == -1 {
-		return 0, false, fmt.Errorf("invalid header line: missing colon")
+		return 0, false, fmt.Errorf("%w: missing colon", ErrMalformedFieldLine)
 	}
 
 	keyBytes := lineBytes[:colonIndex]
-	if bytes.HasSuffix(keyBytes, []byte(" ")) {
-		return 0, false, fmt.Errorf("invalid header line: space before colon")
+	if bytes.HasSuffix(keyBytes, []byte(" ")) || bytes.HasSuffix(keyBytes, []byte("\t")) {
+		return 0, false, ErrSpaceBeforeColon
 	}
 
-	keyString := string(bytes.TrimSpace(keyBytes))
+	keyString := string(keyBytes)
 	if len(keyString) == 0 {
-		return 0, false, fmt.Errorf("invalid header line: empty key")
+		return 0, false, fmt.Errorf("%w: empty key", ErrMalformedFieldLine)
 	}
 
 	// NEW: Validate all characters in the key.
 	for i := 0; i < len(keyString); i++ {
 		if !isValidTchar(keyString[i]) {
-			return 0, false, fmt.Errorf("invalid character '%c' in header key", keyString[i])
+			return 0, false, fmt.Errorf("%w: '%c'", ErrInvalidFieldName, keyString[i])
 		}
 	}
 
-	valueBytes := bytes.TrimSpace(lineBytes[colonIndex+1:])
+	// Only SP and HTAB are optional whitespace around a value.
+	valueBytes := bytes.Trim(lineBytes[colonIndex+1:], " \t")
 	value := string(valueBytes)
-	lowerKey := strings.ToLower(keyString)
-
-	// check if the header key already exists
-	if existingValue, ok := h[lowerKey]; ok {
-		// if it exists append the new value separated by a comma
-		h[lowerKey] = existingValue + "," + value
-	} else {
-		// otherwise just add the new header
-		h[lowerKey] = value
+	if !ValidFieldValue(value) {
+		return 0, false, fmt.Errorf("%w: %q", ErrInvalidFieldValue, value)
 	}
 
+	// Repeated fields are kept as separate entries; Get still sees them
+	// combined with commas.
+	h.Add(keyString, value)
+
 	return bytesConsumed, false, nil
 }
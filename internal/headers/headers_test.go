@@ -11,7 +11,7 @@ func TestHeaders_Parse(t *testing.T) {
 
 	t.Run("Duplicate header key should append value", func(t *testing.T) {
 		headers := NewHeaders()
-		headers["accept-language"] = "en-US"
+		headers.Set("accept-language", "en-US")
 
 		data:= [] byte("Accept-Language: en-GB\r\n")
 		n, done, err:= headers.Parse(data)
@@ -19,7 +19,7 @@ func TestHeaders_Parse(t *testing.T) {
 		assert.False(t, done)
 		assert.NotZero(t, n)
 
-		assert.Equal(t, "en-US,en-GB", headers["accept-language"])
+		assert.Equal(t, "en-US,en-GB", headers.Get("accept-language"))
 	})
 	t.Run("Valid single header with case-insensitivity", func(t *testing.T) {
 		headers := NewHeaders()
@@ -28,7 +28,7 @@ func TestHeaders_Parse(t *testing.T) {
 
 		require.NoError(t, err)
 		require.NotNil(t, headers)
-		assert.Equal(t, "localhost:42069", headers["host"])
+		assert.Equal(t, "localhost:42069", headers.Get("host"))
 		assert.Equal(t, 23, n)
 		assert.False(t, done)
 	})
@@ -39,14 +39,14 @@ func TestHeaders_Parse(t *testing.T) {
 		n, done, err := headers.Parse(data)
 
 		require.NoError(t, err)
-		assert.Equal(t, "application/json", headers["content-type"])
+		assert.Equal(t, "application/json", headers.Get("content-type"))
 		assert.Equal(t, 39, n)
 		assert.False(t, done)
 	})
 
 	t.Run("Valid 2 headers with existing headers", func(t *testing.T) {
 		headers := NewHeaders()
-		headers["existing"] = "true"
+		headers.Set("existing", "true")
 
 		data1 := []byte("Host: example.com\r\nUser-Agent: test\r\n")
 		n1, done1, err1 := headers.Parse(data1)
@@ -60,9 +60,9 @@ func TestHeaders_Parse(t *testing.T) {
 		assert.Equal(t, 18, n2)
 		assert.False(t, done2)
 
-		assert.Equal(t, "true", headers["existing"])
-		assert.Equal(t, "example.com", headers["host"])
-		assert.Equal(t, "test", headers["user-agent"])
+		assert.Equal(t, "true", headers.Get("existing"))
+		assert.Equal(t, "example.com", headers.Get("host"))
+		assert.Equal(t, "test", headers.Get("user-agent"))
 	})
 
 	t.Run("Valid done", func(t *testing.T) {
@@ -81,6 +81,7 @@ func TestHeaders_Parse(t *testing.T) {
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "space before colon")
+		assert.ErrorIs(t, err, ErrSpaceBeforeColon)
 		assert.Equal(t, 0, n)
 		assert.False(t, done)
 	})
@@ -92,6 +93,10 @@ func TestHeaders_Parse(t *testing.T) {
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid character")
+		assert.ErrorIs(t, err, ErrInvalidFieldName)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, 400, parseErr.Status)
 		assert.Equal(t, 0, n)
 		assert.False(t, done)
 	})
@@ -105,3 +110,107 @@ func TestHeaders_Parse(t *testing.T) {
 		assert.False(t, done)
 	})
 }
+
+func TestHeaders_MultipleValues(t *testing.T) {
+	t.Run("Repeated fields keep their own values", func(t *testing.T) {
+		h := NewHeaders()
+		data := []byte("Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nHost: x\r\nset-cookie: b=2\r\n\r\n")
+		for {
+			n, done, err := h.Parse(data)
+			require.NoError(t, err)
+			data = data[n:]
+			if done {
+				break
+			}
+		}
+
+		assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, h.Values("Set-Cookie"))
+		// Get keeps the old combined view.
+		assert.Equal(t, "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT,b=2", h.Get("set-cookie"))
+		assert.Equal(t, 3, h.Len())
+		assert.Equal(t, []string{"Set-Cookie", "Host"}, h.Names())
+	})
+
+	t.Run("All iterates in order with canonical names", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("x-b", "1")
+		h.Add("CONTENT-TYPE", "text/plain")
+		h.Add("X-B", "2")
+
+		var lines []string
+		for name, value := range h.All() {
+			lines = append(lines, name+": "+value)
+		}
+		assert.Equal(t, []string{"X-B: 1", "Content-Type: text/plain", "X-B: 2"}, lines)
+	})
+
+	t.Run("Set replaces in place, Del removes all", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("A", "1")
+		h.Add("B", "2")
+		h.Add("a", "3")
+		h.Add("C", "4")
+
+		h.Set("A", "only")
+		assert.Equal(t, []string{"A", "B", "C"}, h.Names())
+		assert.Equal(t, []string{"only"}, h.Values("a"))
+
+		h.Del("b")
+		assert.False(t, h.Has("B"))
+		assert.Equal(t, []string{"A", "C"}, h.Names())
+
+		h.Set("D", "new")
+		assert.Equal(t, []string{"A", "C", "D"}, h.Names())
+	})
+
+	t.Run("Empty value is still present", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("X-Empty", "")
+		assert.True(t, h.Has("x-empty"))
+		assert.Equal(t, "", h.Get("x-empty"))
+	})
+
+	t.Run("Clone is independent", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("A", "1")
+		c := h.Clone()
+		c.Add("A", "2")
+		assert.Equal(t, []string{"1"}, h.Values("A"))
+		assert.Equal(t, []string{"1", "2"}, c.Values("A"))
+	})
+
+	t.Run("Nil headers read as empty", func(t *testing.T) {
+		var h *Headers
+		assert.Equal(t, "", h.Get("A"))
+		assert.Equal(t, 0, h.Len())
+		for range h.All() {
+			t.Fatal("nil headers yielded a field")
+		}
+		assert.Nil(t, h.Names())
+		c := h.Clone()
+		require.NotNil(t, c)
+		c.Add("A", "1")
+		assert.Equal(t, "1", c.Get("A"))
+	})
+}
+
+func TestValidField(t *testing.T) {
+	t.Run("Names", func(t *testing.T) {
+		assert.True(t, ValidFieldName("X-Request-Id"))
+		assert.True(t, ValidFieldName("!#$%&'*+-.^_`|~09az"))
+		assert.False(t, ValidFieldName(""))
+		assert.False(t, ValidFieldName("X Request"))
+		assert.False(t, ValidFieldName("X:Y"))
+		assert.False(t, ValidFieldName("X\r\nY"))
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		assert.True(t, ValidFieldValue(""))
+		assert.True(t, ValidFieldValue("text/html; charset=utf-8"))
+		assert.True(t, ValidFieldValue("a\tb"))
+		assert.False(t, ValidFieldValue("a\r\nb"))
+		assert.False(t, ValidFieldValue("a\nb"))
+		assert.False(t, ValidFieldValue("a\rb"))
+		assert.False(t, ValidFieldValue("a\x00b"))
+	})
+}
@@ -0,0 +1,74 @@
+// Package middleware provides server.Middleware that most services want:
+// request logging, panic recovery, request IDs and timing.
+//
+// Each one is a server.Middleware and can be combined with server.Chain or
+// added with Use on a server or router:
+//
+//	r.Use(middleware.RequestID, middleware.Logging, middleware.Recover, middleware.Timing)
+//
+// Order matters: RequestID first lets Logging print the ID, and Logging
+// outside Recover logs the 500 a panic turns into.
+package middleware
+
+import (
+	"log"
+	"time"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/server"
+)
+
+// statusRecorder remembers the status and body size a handler wrote, so
+// they can be reported once it returns.
+type statusRecorder struct {
+	server.ResponseWriter
+	status  response.StatusCode
+	written int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode response.StatusCode) {
+	// 1xx responses are interim; the final status comes later.
+	if r.status == 0 && !statusCode.IsInformational() {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = response.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.written += n
+	return n, err
+}
+
+// Logging logs one line per request with its status, body size and how
+// long the handler took, e.g.
+//
+//	GET /users/1 HTTP/1.1 -> 200 (42 bytes) in 1.2ms [id 3f2a...]
+func Logging(next server.Handler) server.Handler {
+	return func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		herr := next(rec, req)
+
+		// A returned error replaces the response unless it had already
+		// started, so it decides what the client sees.
+		status, size := rec.status, rec.written
+		if herr != nil && status == 0 {
+			status, size = herr.StatusCode, len(herr.Message)
+		}
+		if status == 0 {
+			status = response.StatusOK
+		}
+		line := req.RequestLine
+		if id := GetRequestID(req); id != "" {
+			log.Printf("%s %s HTTP/%s -> %d (%d bytes) in %v [id %s]", line.Method, line.RequestTarget, line.HttpVersion, status, size, time.Since(start), id)
+		} else {
+			log.Printf("%s %s HTTP/%s -> %d (%d bytes) in %v", line.Method, line.RequestTarget, line.HttpVersion, status, size, time.Since(start))
+		}
+		return herr
+	}
+}
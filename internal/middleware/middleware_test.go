@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"HttpFromTcp/internal/headers"
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a server.ResponseWriter that keeps the response in memory.
+type recorder struct {
+	header  *headers.Headers
+	trailer *headers.Headers
+	status  response.StatusCode
+	body    bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: headers.NewHeaders(), trailer: headers.NewHeaders()}
+}
+
+func (r *recorder) Header() *headers.Headers  { return r.header }
+func (r *recorder) Trailer() *headers.Headers { return r.trailer }
+
+func (r *recorder) WriteHeader(statusCode response.StatusCode) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(response.StatusOK)
+	return r.body.Write(p)
+}
+
+func newRequest(method, target string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+}
+
+// captureLog redirects the standard logger for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLogging(t *testing.T) {
+	t.Run("Logs status and size", func(t *testing.T) {
+		logs := captureLog(t)
+		h := Logging(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+			w.WriteHeader(response.StatusCreated)
+			fmt.Fprint(w, "made it")
+			return nil
+		})
+
+		require.Nil(t, h(newRecorder(), newRequest("POST", "/things")))
+		assert.Contains(t, logs.String(), "POST /things HTTP/1.1 -> 201 (7 bytes)")
+	})
+
+	t.Run("Logs the status of a returned error", func(t *testing.T) {
+		logs := captureLog(t)
+		h := Logging(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+			return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "gone\n"}
+		})
+
+		herr := h(newRecorder(), newRequest("GET", "/missing"))
+		require.NotNil(t, herr)
+		assert.Contains(t, logs.String(), "GET /missing HTTP/1.1 -> 404 (5 bytes)")
+	})
+
+	t.Run("Informational responses aren't the status", func(t *testing.T) {
+		logs := captureLog(t)
+		h := Logging(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+			w.WriteHeader(response.StatusContinue)
+			fmt.Fprint(w, "ok")
+			return nil
+		})
+
+		require.Nil(t, h(newRecorder(), newRequest("PUT", "/upload")))
+		assert.Contains(t, logs.String(), "PUT /upload HTTP/1.1 -> 200 (2 bytes)")
+	})
+}
+
+func TestRecover(t *testing.T) {
+	logs := captureLog(t)
+	h := Recover(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		panic("boom")
+	})
+
+	herr := h(newRecorder(), newRequest("GET", "/explode"))
+	require.NotNil(t, herr)
+	assert.Equal(t, response.StatusInternalServerError, herr.StatusCode)
+	assert.Contains(t, logs.String(), "Panic serving GET /explode: boom")
+	assert.Contains(t, logs.String(), "goroutine")
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		seen = GetRequestID(req)
+		return nil
+	})
+
+	t.Run("Generates an ID", func(t *testing.T) {
+		rec := newRecorder()
+		require.Nil(t, h(rec, newRequest("GET", "/")))
+		assert.Len(t, seen, 32)
+		assert.Equal(t, seen, rec.header.Get(RequestIDHeader))
+	})
+
+	t.Run("Keeps the client's ID", func(t *testing.T) {
+		req := newRequest("GET", "/")
+		req.Headers.Set(RequestIDHeader, "abc-123")
+		rec := newRecorder()
+		require.Nil(t, h(rec, req))
+		assert.Equal(t, "abc-123", seen)
+		assert.Equal(t, "abc-123", rec.header.Get(RequestIDHeader))
+	})
+
+	t.Run("Replaces an unsafe ID", func(t *testing.T) {
+		req := newRequest("GET", "/")
+		req.Headers.Set(RequestIDHeader, "evil id\tinjected")
+		require.Nil(t, h(newRecorder(), req))
+		assert.Len(t, seen, 32)
+		// The client's header is left as it was sent.
+		assert.Equal(t, "evil id\tinjected", req.Headers.Get(RequestIDHeader))
+	})
+
+	t.Run("No ID without the middleware", func(t *testing.T) {
+		req := newRequest("GET", "/")
+		req.Headers.Set(RequestIDHeader, "evil id\tinjected")
+		assert.Empty(t, GetRequestID(req))
+	})
+}
+
+func TestTiming(t *testing.T) {
+	t.Run("Buffered response", func(t *testing.T) {
+		h := Timing(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+			fmt.Fprint(w, "hi")
+			return nil
+		})
+		rec := newRecorder()
+		require.Nil(t, h(rec, newRequest("GET", "/")))
+		assert.True(t, strings.HasPrefix(rec.header.Get(ServerTimingHeader), "app;dur="))
+	})
+
+	t.Run("Set before the status is committed", func(t *testing.T) {
+		var atHeader string
+		h := Timing(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+			w.WriteHeader(response.StatusAccepted)
+			atHeader = w.Header().Get(ServerTimingHeader)
+			return nil
+		})
+		require.Nil(t, h(newRecorder(), newRequest("GET", "/")))
+		assert.True(t, strings.HasPrefix(atHeader, "app;dur="))
+	})
+}
+
+func TestChainOrder(t *testing.T) {
+	logs := captureLog(t)
+	h := server.Chain(RequestID, Logging, Recover)(func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		panic("boom")
+	})
+
+	rec := newRecorder()
+	herr := h(rec, newRequest("GET", "/"))
+	require.NotNil(t, herr)
+	// Logging sits outside Recover, so it sees the 500, and after
+	// RequestID, so it can print the ID.
+	assert.Contains(t, logs.String(), "-> 500")
+	assert.Contains(t, logs.String(), "[id "+rec.header.Get(RequestIDHeader)+"]")
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"log"
+	"runtime/debug"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/server"
+)
+
+// Recover turns a panic in the handler into a 500 Internal Server Error
+// and logs the panic with its stack trace. If the handler had already
+// started sending the response, the server closes the connection instead.
+func Recover(next server.Handler) server.Handler {
+	return func(w server.ResponseWriter, req *request.Request) (herr *server.HandlerError) {
+		defer func() {
+			if v := recover(); v != nil {
+				log.Printf("Panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+				herr = &server.HandlerError{
+					StatusCode: response.StatusInternalServerError,
+					Message:    response.StatusText(response.StatusInternalServerError) + "\n",
+				}
+			}
+		}()
+		return next(w, req)
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/server"
+)
+
+// RequestIDHeader carries the ID of a request, both from the client (or a
+// proxy in front of the server) and back in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// RequestID gives every request an ID. An ID sent by the client in
+// X-Request-Id is kept if it looks sane; otherwise a random one is
+// generated. The ID is echoed in the response and available to later
+// handlers through GetRequestID.
+func RequestID(next server.Handler) server.Handler {
+	return func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		id := req.Headers.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		req.SetID(id)
+		w.Header().Set(RequestIDHeader, id)
+		return next(w, req)
+	}
+}
+
+// GetRequestID returns the ID assigned by RequestID, or "" if the request
+// didn't go through it. The client's X-Request-Id header is only trusted
+// once RequestID has checked it.
+func GetRequestID(req *request.Request) string {
+	return req.ID()
+}
+
+// validRequestID reports whether a client-supplied ID is safe to log and
+// echo back: short, and limited to letters, digits and "-_.".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns 16 random bytes, hex encoded.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
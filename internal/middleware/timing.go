@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/server"
+)
+
+// ServerTimingHeader reports how long the handler took (see the W3C
+// Server Timing spec), e.g. "Server-Timing: app;dur=12.345".
+const ServerTimingHeader = "Server-Timing"
+
+// Timing measures the handler and reports the duration in milliseconds in
+// a Server-Timing header. Buffered responses get the full handler time.
+// Responses whose headers go out while the handler is still running (a
+// declared Content-Length, or chunked streaming) get the time until then.
+func Timing(next server.Handler) server.Handler {
+	return func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		tw := &timingWriter{ResponseWriter: w, start: time.Now()}
+		herr := next(tw, req)
+		// Headers that haven't been sent yet can still be updated.
+		tw.stamp()
+		return herr
+	}
+}
+
+// timingWriter stamps the Server-Timing header just before the handler
+// commits to a status, in case that sends the headers right away.
+type timingWriter struct {
+	server.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (w *timingWriter) stamp() {
+	ms := float64(time.Since(w.start).Microseconds()) / 1000
+	w.Header().Set(ServerTimingHeader, fmt.Sprintf("app;dur=%.3f", ms))
+}
+
+func (w *timingWriter) WriteHeader(statusCode response.StatusCode) {
+	if !w.wroteHeader && !statusCode.IsInformational() {
+		w.wroteHeader = true
+		w.stamp()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *timingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(response.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,153 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSizeLine caps a chunk-size line, extensions included.
+const maxChunkSizeLine = 4096
+
+// checkTransferEncoding checks the Transfer-Encoding fields of a request.
+// chunked must be the final coding, since it is what frames the body, and
+// may only be applied once (RFC 9112, Section 6.1). Other codings are left
+// for the handler to undo.
+func checkTransferEncoding(values []string) error {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			codings = append(codings, strings.Trim(coding, " \t"))
+		}
+	}
+	for i, coding := range codings {
+		if coding == "" {
+			return fmt.Errorf("%w: empty transfer coding", ErrInvalidTransferEncoding)
+		}
+		if strings.EqualFold(coding, "chunked") && i != len(codings)-1 {
+			return fmt.Errorf("%w: chunked must be the last coding, and only once", ErrInvalidTransferEncoding)
+		}
+	}
+	if last := codings[len(codings)-1]; !strings.EqualFold(last, "chunked") {
+		return fmt.Errorf("%w '%s'", ErrUnsupportedTransferEncoding, strings.Join(values, ", "))
+	}
+	return nil
+}
+
+// parseChunked decodes a body sent with Transfer-Encoding: chunked:
+//
+//	chunk-size [ ; chunk-ext ] CRLF
+//	chunk-data CRLF
+//	...
+//	0 [ ; chunk-ext ] CRLF
+//	*( field-line CRLF )
+//	CRLF
+//
+// The decoded chunk data is appended to the body.
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.state {
+	case StateChunkSize:
+		if hasBareLF(data) {
+			return 0, fmt.Errorf("%w: size line ends in LF without CR", ErrMalformedChunk)
+		}
+		crlfIndex := bytes.Index(data, []byte("\r\n"))
+		if crlfIndex == -1 {
+			// Extensions could otherwise make this line arbitrarily long.
+			if len(data) > maxChunkSizeLine {
+				return 0, fmt.Errorf("%w: size line longer than %d bytes", ErrMalformedChunk, maxChunkSizeLine)
+			}
+			return 0, nil
+		}
+		size, err := parseChunkSize(data[:crlfIndex])
+		if err != nil {
+			return 0, err
+		}
+		if size > math.MaxInt {
+			return 0, fmt.Errorf("%w: chunk of %d bytes", ErrBodyTooLarge, size)
+		}
+		if err := r.checkBodySize(int(size)); err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			// The last chunk; what follows is the (possibly empty) trailer section.
+			r.state = StateTrailers
+		} else {
+			r.chunkRemaining = size
+			r.state = StateChunkData
+		}
+		return crlfIndex + 2, nil
+
+	case StateChunkData:
+		bytesToConsume := int64(len(data))
+		if bytesToConsume > r.chunkRemaining {
+			bytesToConsume = r.chunkRemaining
+		}
+		r.appendBody(data[:bytesToConsume])
+		r.chunkRemaining -= bytesToConsume
+		if r.chunkRemaining == 0 {
+			r.state = StateChunkDataEnd
+		}
+		return int(bytesToConsume), nil
+
+	case StateChunkDataEnd:
+		// Every chunk's data is followed by a CRLF.
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if data[0] != '\r' || data[1] != '\n' {
+			return 0, fmt.Errorf("%w: missing CRLF after chunk data", ErrMalformedChunk)
+		}
+		r.state = StateChunkSize
+		return 2, nil
+
+	case StateTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("invalid trailer: %w", err)
+		}
+		if err := r.checkFieldSection(data, n, done); err != nil {
+			return 0, err
+		}
+		r.fieldBytes += n
+		if done {
+			r.state = StateDone
+		}
+		return n, nil
+	}
+	return 0, nil
+}
+
+// parseChunkSize reads the hex chunk size from a chunk-size line, ignoring
+// any chunk extensions (";name=value") that follow it.
+func parseChunkSize(line []byte) (int64, error) {
+	// A lone CR hiding in an extension is a line ending to some parsers.
+	if bytes.IndexByte(line, '\r') != -1 {
+		return 0, fmt.Errorf("%w: CR inside the chunk size line", ErrMalformedChunk)
+	}
+	sizePart := line
+	if semicolon := bytes.IndexByte(line, ';'); semicolon != -1 {
+		sizePart = line[:semicolon]
+	}
+	// Whitespace is allowed between the size and its extensions.
+	sizeStr := string(bytes.TrimRight(sizePart, " \t"))
+	if sizeStr == "" {
+		return 0, fmt.Errorf("%w: chunk size line '%s' is missing the size", ErrMalformedChunk, string(line))
+	}
+	// ParseInt would also accept a leading sign, which HEXDIG doesn't allow.
+	for i := 0; i < len(sizeStr); i++ {
+		if !isHexDigit(sizeStr[i]) {
+			return 0, fmt.Errorf("%w: invalid chunk size '%s'", ErrMalformedChunk, sizeStr)
+		}
+	}
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid chunk size '%s': %v", ErrMalformedChunk, sizeStr, err)
+	}
+	return size, nil
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
@@ -0,0 +1,58 @@
+package request
+
+import "HttpFromTcp/internal/headers"
+
+// ParseError is an error found while reading a request. Status is the HTTP
+// status code a server should answer the request with. It is the same type
+// as headers.ParseError, so a single errors.As finds the status of any
+// error from reading a request, header and trailer lines included.
+type ParseError = headers.ParseError
+
+// Errors returned while reading a request. Most are wrapped with details
+// about the offending input, so compare them with errors.Is. Malformed
+// header and trailer lines are reported with the errors from the headers
+// package.
+var (
+	// ErrMalformedRequestLine is a request line that isn't
+	// "method SP request-target SP HTTP-version".
+	ErrMalformedRequestLine = &ParseError{Status: 400, Message: "invalid request line"}
+	// ErrInvalidTarget is a request-target that can't be parsed, or whose
+	// form doesn't fit the method.
+	ErrInvalidTarget = &ParseError{Status: 400, Message: "invalid request target"}
+	// ErrInvalidMethod is a method that isn't an uppercase token.
+	ErrInvalidMethod = &ParseError{Status: 400, Message: "invalid method"}
+	// ErrMethodNotImplemented is a well-formed method the server doesn't know.
+	ErrMethodNotImplemented = &ParseError{Status: 501, Message: "method not implemented"}
+	// ErrUnsupportedVersion is an HTTP version other than 1.0 or 1.1.
+	ErrUnsupportedVersion = &ParseError{Status: 505, Message: "unsupported http version"}
+	// ErrInvalidContentLength is a Content-Length that isn't a valid length.
+	ErrInvalidContentLength = &ParseError{Status: 400, Message: "invalid Content-Length header"}
+	// ErrUnsupportedTransferEncoding is a transfer coding other than chunked.
+	ErrUnsupportedTransferEncoding = &ParseError{Status: 501, Message: "unsupported Transfer-Encoding"}
+	// ErrInvalidTransferEncoding is a Transfer-Encoding that can't frame a
+	// request body, e.g. one where chunked isn't the final coding.
+	ErrInvalidTransferEncoding = &ParseError{Status: 400, Message: "invalid Transfer-Encoding header"}
+	// ErrAmbiguousFraming is a request whose body length could be read more
+	// than one way, such as one with both Content-Length and
+	// Transfer-Encoding. Answering it risks request smuggling.
+	ErrAmbiguousFraming = &ParseError{Status: 400, Message: "ambiguous message framing"}
+	// ErrMalformedChunk is a chunked body that breaks the chunked framing.
+	ErrMalformedChunk = &ParseError{Status: 400, Message: "invalid chunk"}
+	// ErrExpectationFailed is an Expect header asking for anything other
+	// than 100-continue, the only expectation HTTP defines.
+	ErrExpectationFailed = &ParseError{Status: 417, Message: "unsupported expectation"}
+	// ErrIncompleteRequest is returned when the stream ends partway through
+	// a request.
+	ErrIncompleteRequest = &ParseError{Status: 400, Message: "incomplete request: stream ended before request was fully parsed"}
+
+	// ErrRequestLineTooLong is returned when the request line is longer
+	// than Limits.MaxRequestLineBytes.
+	ErrRequestLineTooLong = &ParseError{Status: 414, Message: "request line too long"}
+	// ErrHeadersTooLarge is returned when the header (or trailer) section
+	// is bigger than Limits.MaxHeaderBytes or has more than
+	// Limits.MaxHeaderCount fields.
+	ErrHeadersTooLarge = &ParseError{Status: 431, Message: "request header fields too large"}
+	// ErrBodyTooLarge is returned when the body is longer than
+	// Limits.MaxBodyBytes.
+	ErrBodyTooLarge = &ParseError{Status: 413, Message: "request body too large"}
+)
@@ -0,0 +1,145 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"mime"
+)
+
+const (
+	// maxFormBytes caps an application/x-www-form-urlencoded body, and the
+	// total size of the non-file fields of a multipart form.
+	maxFormBytes = 10 << 20
+	// defaultMaxMemory is how much of a multipart form FormValue and
+	// FormFile keep in memory before spilling file parts to disk.
+	defaultMaxMemory = 32 << 20
+)
+
+// Errors returned while parsing form bodies.
+var (
+	// ErrMalformedForm is a form body that can't be decoded.
+	ErrMalformedForm = &ParseError{Status: 400, Message: "malformed form body"}
+	// ErrFormTooLarge is a form whose fields don't fit in maxFormBytes.
+	ErrFormTooLarge = &ParseError{Status: 413, Message: "form body too large"}
+	// ErrNotMultipart is returned by ParseMultipartForm for a request that
+	// isn't multipart/form-data.
+	ErrNotMultipart = &ParseError{Status: 415, Message: "request Content-Type isn't multipart/form-data"}
+	// ErrMissingFile is returned by FormFile when there is no such file.
+	ErrMissingFile = &ParseError{Status: 400, Message: "no such file in form"}
+)
+
+// ParseForm fills in Form and PostForm. For POST, PUT and PATCH requests
+// with an application/x-www-form-urlencoded body, the body is read and
+// decoded into PostForm. Form holds the body values followed by the values
+// from the query string, so body values win in Form.Get.
+//
+// ParseForm reads the body, so for a streamed request it must be called
+// before anything else reads it. Calling it again does nothing.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	r.PostForm = Query{}
+	if hasFormBody(r.RequestLine.Method) && r.mediaType() == "application/x-www-form-urlencoded" {
+		data, err := io.ReadAll(io.LimitReader(r.BodyReader(), maxFormBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxFormBytes {
+			return ErrFormTooLarge
+		}
+		values, err := ParseQuery(string(data))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedForm, err)
+		}
+		r.PostForm = values
+	}
+	r.Form = mergeQueries(r.PostForm, r.Target.Query)
+	return nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm,
+// keeping up to maxMemory bytes of file parts in memory and storing the
+// rest in temporary files (see MultipartForm.RemoveAll). Non-file fields
+// are also added to PostForm and Form, ahead of the query values. It calls
+// ParseForm first, and does nothing if called again.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if r.mediaType() != "multipart/form-data" {
+		return ErrNotMultipart
+	}
+	_, params, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return fmt.Errorf("%w: missing or invalid boundary", ErrMalformedForm)
+	}
+
+	form, err := readMultipartForm(r.BodyReader(), boundary, maxMemory)
+	if err != nil {
+		return err
+	}
+	r.MultipartForm = form
+	for key, values := range form.Value {
+		r.PostForm[key] = append(r.PostForm[key], values...)
+	}
+	r.Form = mergeQueries(r.PostForm, r.Target.Query)
+	return nil
+}
+
+// FormValue returns the first value for key from the body or the query,
+// parsing the form if needed. Parse errors are ignored; call ParseForm or
+// ParseMultipartForm to see them.
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil || r.MultipartForm == nil && r.mediaType() == "multipart/form-data" {
+		r.ParseMultipartForm(defaultMaxMemory)
+	}
+	return r.Form.Get(key)
+}
+
+// FormFile returns the first file uploaded under key, parsing the
+// multipart form if needed.
+func (r *Request) FormFile(key string) (*FileHeader, error) {
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(defaultMaxMemory); err != nil {
+			return nil, err
+		}
+	}
+	if files := r.MultipartForm.File[key]; len(files) > 0 {
+		return files[0], nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrMissingFile, key)
+}
+
+// mediaType returns the lowercased media type of the body, without
+// parameters.
+func (r *Request) mediaType() string {
+	mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// hasFormBody reports whether requests with this method carry form data
+// in their body.
+func hasFormBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+// mergeQueries returns a new Query with the values of first followed by
+// those of second.
+func mergeQueries(first, second Query) Query {
+	merged := Query{}
+	for key, values := range first {
+		merged[key] = append(merged[key], values...)
+	}
+	for key, values := range second {
+		merged[key] = append(merged[key], values...)
+	}
+	return merged
+}
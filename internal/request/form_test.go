@@ -0,0 +1,151 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// formRequest builds a request with the given target, Content-Type and body.
+func formRequest(t *testing.T, target, contentType, body string) *Request {
+	t.Helper()
+	raw := fmt.Sprintf("POST %s HTTP/1.1\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", target, contentType, len(body), body)
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+// multipartBody joins parts into a multipart body with boundary "XyZ".
+func multipartBody(parts ...string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString("--XyZ\r\n" + part + "\r\n")
+	}
+	b.WriteString("--XyZ--\r\n")
+	return b.String()
+}
+
+func TestRequest_ParseForm(t *testing.T) {
+	t.Run("Urlencoded body merged with the query", func(t *testing.T) {
+		r := formRequest(t, "/submit?page=2&name=fromquery", "application/x-www-form-urlencoded", "name=Ada+Lovelace&lang=en&lang=fr")
+		require.NoError(t, r.ParseForm())
+
+		assert.Equal(t, "Ada Lovelace", r.PostForm.Get("name"))
+		assert.Equal(t, []string{"en", "fr"}, r.PostForm["lang"])
+		assert.False(t, r.PostForm.Has("page"))
+		// Body values come first in Form.
+		assert.Equal(t, []string{"Ada Lovelace", "fromquery"}, r.Form["name"])
+		assert.Equal(t, "2", r.FormValue("page"))
+	})
+
+	t.Run("Other content types only get the query", func(t *testing.T) {
+		r := formRequest(t, "/submit?a=1", "application/json", `{"a":2}`)
+		require.NoError(t, r.ParseForm())
+		assert.Empty(t, r.PostForm)
+		assert.Equal(t, "1", r.Form.Get("a"))
+	})
+
+	t.Run("Bad escape", func(t *testing.T) {
+		r := formRequest(t, "/", "application/x-www-form-urlencoded", "a=%zz")
+		assert.ErrorIs(t, r.ParseForm(), ErrMalformedForm)
+	})
+
+	t.Run("Streamed body is read once", func(t *testing.T) {
+		reader := NewReader(strings.NewReader("POST /?q=1 HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 7\r\n\r\nx=hello"))
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+		require.NoError(t, r.ParseForm())
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "hello", r.Form.Get("x"))
+		assert.Equal(t, "1", r.Form.Get("q"))
+	})
+}
+
+func TestRequest_ParseMultipartForm(t *testing.T) {
+	body := "preamble to ignore\r\n" + multipartBody(
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\nHoliday photos",
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"C:\\\\pics\\\\beach.jpg\"\r\nContent-Type: image/jpeg\r\n\r\nJPEGDATA\r\nwith a CRLF --XyZ inside",
+		"Content-Disposition: form-data; name=\"empty\"; filename=\"\"\r\n\r\n",
+	)
+
+	t.Run("Values and files", func(t *testing.T) {
+		r := formRequest(t, "/upload?album=7", `multipart/form-data; boundary="XyZ"`, body)
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		defer r.MultipartForm.RemoveAll()
+
+		assert.Equal(t, "Holiday photos", r.FormValue("title"))
+		assert.Equal(t, "7", r.FormValue("album"))
+		assert.True(t, r.PostForm.Has("empty"))
+
+		fh, err := r.FormFile("photo")
+		require.NoError(t, err)
+		assert.Equal(t, "beach.jpg", fh.Filename)
+		assert.Equal(t, "image/jpeg", fh.Header.Get("Content-Type"))
+		f, err := fh.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		f.Close()
+		assert.Equal(t, "JPEGDATA\r\nwith a CRLF --XyZ inside", string(data))
+		assert.Equal(t, int64(len(data)), fh.Size)
+
+		_, err = r.FormFile("missing")
+		assert.ErrorIs(t, err, ErrMissingFile)
+	})
+
+	t.Run("Large files spill to disk", func(t *testing.T) {
+		big := strings.Repeat("0123456789", 2000)
+		body := multipartBody(
+			"Content-Disposition: form-data; name=\"small\"; filename=\"a.txt\"\r\n\r\nsmall",
+			"Content-Disposition: form-data; name=\"big\"; filename=\"b.bin\"\r\n\r\n"+big,
+		)
+		r := formRequest(t, "/", "multipart/form-data; boundary=XyZ", body)
+		require.NoError(t, r.ParseMultipartForm(100))
+
+		small := r.MultipartForm.File["small"][0]
+		assert.Empty(t, small.tmpfile)
+		bigFile := r.MultipartForm.File["big"][0]
+		require.NotEmpty(t, bigFile.tmpfile)
+		assert.Equal(t, int64(len(big)), bigFile.Size)
+		f, err := bigFile.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		f.Close()
+		assert.Equal(t, big, string(data))
+
+		require.NoError(t, r.MultipartForm.RemoveAll())
+		_, err = os.Stat(bigFile.tmpfile)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Not multipart", func(t *testing.T) {
+		r := formRequest(t, "/", "application/x-www-form-urlencoded", "a=1")
+		assert.ErrorIs(t, r.ParseMultipartForm(1<<20), ErrNotMultipart)
+	})
+
+	t.Run("Malformed bodies", func(t *testing.T) {
+		cases := map[string]string{
+			"Missing closing boundary": "--XyZ\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nvalue",
+			"Part without a name":      multipartBody("Content-Type: text/plain\r\n\r\nvalue"),
+			"Junk after boundary":      "--XyZjunk\r\n\r\n--XyZ--",
+			"No boundary at all":       "just some text",
+		}
+		for name, body := range cases {
+			t.Run(name, func(t *testing.T) {
+				r := formRequest(t, "/", "multipart/form-data; boundary=XyZ", body)
+				assert.ErrorIs(t, r.ParseMultipartForm(1<<20), ErrMalformedForm)
+			})
+		}
+	})
+
+	t.Run("Missing boundary parameter", func(t *testing.T) {
+		r := formRequest(t, "/", "multipart/form-data", body)
+		assert.ErrorIs(t, r.ParseMultipartForm(1<<20), ErrMalformedForm)
+	})
+}
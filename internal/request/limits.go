@@ -0,0 +1,79 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Limits caps how much a client may send in a single request. A zero or
+// negative field means no limit.
+type Limits struct {
+	// MaxRequestLineBytes caps the request line, CRLF excluded.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes caps the header section, and separately the trailer
+	// section of a chunked body, line endings included.
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header (or trailer) fields.
+	MaxHeaderCount int
+	// MaxBodyBytes caps the decoded body, whether it is read into Body or
+	// streamed through BodyReader.
+	MaxBodyBytes int
+}
+
+// DefaultLimits returns limits suitable for a server facing untrusted
+// clients.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestLineBytes: 8 * 1024,
+		MaxHeaderBytes:      64 * 1024,
+		MaxHeaderCount:      100,
+		MaxBodyBytes:        10 * 1024 * 1024,
+	}
+}
+
+// checkRequestLine rejects a request line that is, or is bound to become,
+// longer than allowed, without waiting for its CRLF.
+func (r *Request) checkRequestLine(data []byte) error {
+	max := r.limits.MaxRequestLineBytes
+	if max <= 0 {
+		return nil
+	}
+	lineLen := bytes.Index(data, []byte("\r\n"))
+	if lineLen == -1 {
+		lineLen = len(data)
+	}
+	if lineLen > max {
+		return fmt.Errorf("%w: more than %d bytes", ErrRequestLineTooLong, max)
+	}
+	return nil
+}
+
+// checkFieldSection enforces the header limits on a header or trailer
+// section. consumed is what Parse just took from data; done is whether the
+// section ended.
+func (r *Request) checkFieldSection(data []byte, consumed int, done bool) error {
+	if consumed == 0 {
+		// Parse is waiting for the end of a line; don't let that line grow
+		// without bound either.
+		consumed = len(data)
+	} else if !done {
+		r.fieldCount++
+	}
+	if max := r.limits.MaxHeaderBytes; max > 0 && r.fieldBytes+consumed > max {
+		return fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, max)
+	}
+	if max := r.limits.MaxHeaderCount; max > 0 && r.fieldCount > max {
+		return fmt.Errorf("%w: more than %d fields", ErrHeadersTooLarge, max)
+	}
+	return nil
+}
+
+// checkBodySize rejects a body that would grow past MaxBodyBytes once
+// another n bytes are added to it.
+func (r *Request) checkBodySize(n int) error {
+	max := r.limits.MaxBodyBytes
+	if max > 0 && (n > max || r.bodyRead > max-n) {
+		return fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, max)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+
+	"HttpFromTcp/internal/headers"
+)
+
+const (
+	// maxPartHeaderBytes caps the header section of a single part.
+	maxPartHeaderBytes = 16 * 1024
+	// multipartBufferSize is the read buffer used to find boundaries. It
+	// has to hold a whole delimiter line, which is at most ~76 bytes.
+	multipartBufferSize = 8 * 1024
+)
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	// Value holds the fields that aren't files.
+	Value map[string][]string
+	// File holds the uploaded files, by field name.
+	File map[string][]*FileHeader
+}
+
+// RemoveAll deletes the temporary files holding file parts that didn't
+// fit in memory. The server calls it once the handler returns; a handler
+// only needs to call it to free the files sooner, or when it parses a
+// request outside the server.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileHeader describes an uploaded file. Its content is either held in
+// memory or stored in a temporary file; Open reads it from wherever it is.
+type FileHeader struct {
+	Filename string
+	// Header holds the part's own headers, e.g. its Content-Type.
+	Header *headers.Headers
+	Size   int64
+
+	content []byte
+	tmpfile string
+}
+
+// Open returns the content of the file.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// readMultipartForm parses a multipart body (RFC 7578) delimited by
+// boundary.
+func readMultipartForm(body io.Reader, boundary string, maxMemory int64) (_ *MultipartForm, err error) {
+	form := &MultipartForm{Value: map[string][]string{}, File: map[string][]*FileHeader{}}
+	// Don't leave temporary files behind for a form that failed halfway.
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	// Every delimiter is "CRLF--boundary", except the first one, which may
+	// open the body. Starting the stream with a CRLF makes them all alike.
+	br := bufio.NewReaderSize(io.MultiReader(strings.NewReader("\r\n"), body), multipartBufferSize)
+	delimiter := []byte("\r\n--" + boundary)
+
+	// Step 1: Skip the preamble, then read parts until the closing
+	// delimiter.
+	valueBytes := 0
+	for {
+		if _, err := io.Copy(io.Discard, &partReader{br: br, delimiter: delimiter}); err != nil {
+			return nil, err
+		}
+		last, err := readDelimiter(br, delimiter)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return form, nil
+		}
+
+		// Step 2: Read the part's headers and find out what it holds.
+		header, err := readPartHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		disposition, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+		if err != nil || disposition != "form-data" || params["name"] == "" {
+			return nil, fmt.Errorf("%w: part without a form-data name", ErrMalformedForm)
+		}
+		name := params["name"]
+		part := &partReader{br: br, delimiter: delimiter}
+
+		// Step 3: Fields without a filename are plain values. Browsers send
+		// an empty filename for a file input left blank; that is a value too.
+		filename := params["filename"]
+		if filename == "" {
+			value, err := io.ReadAll(io.LimitReader(part, int64(maxFormBytes-valueBytes+1)))
+			if err != nil {
+				return nil, err
+			}
+			valueBytes += len(value)
+			if valueBytes > maxFormBytes {
+				return nil, ErrFormTooLarge
+			}
+			form.Value[name] = append(form.Value[name], string(value))
+			continue
+		}
+
+		// Step 4: Files stay in memory while they fit, then spill to disk.
+		fh := &FileHeader{Filename: baseName(filename), Header: header}
+		if err := fh.store(part, &maxMemory); err != nil {
+			return nil, err
+		}
+		form.File[name] = append(form.File[name], fh)
+	}
+}
+
+// baseName drops any directories from a client-supplied filename, which
+// some browsers send as a full Windows path.
+func baseName(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		return filename[i+1:]
+	}
+	return filename
+}
+
+// store reads a file part, keeping it in memory if it fits in what is left
+// of the memory budget and writing it to a temporary file otherwise.
+func (fh *FileHeader) store(part io.Reader, budget *int64) error {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, *budget+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n <= *budget {
+		*budget -= n
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return nil
+	}
+
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	size, err := io.Copy(file, io.MultiReader(&buf, part))
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	fh.tmpfile = file.Name()
+	fh.Size = size
+	return nil
+}
+
+// readDelimiter consumes a delimiter line. It reports whether it was the
+// closing delimiter ("--boundary--"), after which the epilogue is ignored.
+func readDelimiter(br *bufio.Reader, delimiter []byte) (last bool, err error) {
+	if _, err := br.Discard(len(delimiter)); err != nil {
+		return false, unexpectedEOF(err)
+	}
+	next, err := br.Peek(2)
+	if err == nil && string(next) == "--" {
+		return true, nil
+	}
+	// Anything else must be the end of the line, possibly after some
+	// whitespace ("transport padding").
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return false, unexpectedEOF(err)
+	}
+	if strings.TrimLeft(string(line), " \t") != "\r\n" {
+		return false, fmt.Errorf("%w: junk after boundary", ErrMalformedForm)
+	}
+	return false, nil
+}
+
+// readPartHeader reads the header section of a part, up to its blank line.
+func readPartHeader(br *bufio.Reader) (*headers.Headers, error) {
+	h := headers.NewHeaders()
+	size := 0
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				return nil, fmt.Errorf("%w: part header line too long", ErrMalformedForm)
+			}
+			return nil, unexpectedEOF(err)
+		}
+		size += len(line)
+		if size > maxPartHeaderBytes {
+			return nil, fmt.Errorf("%w: part headers too large", ErrMalformedForm)
+		}
+		n, done, err := h.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("%w: part header line doesn't end in CRLF", ErrMalformedForm)
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// partReader reads the body of one part: everything up to the next
+// delimiter, which is left in the buffer.
+type partReader struct {
+	br        *bufio.Reader
+	delimiter []byte
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	// Look at as much as the buffer holds. A read that ends the stream
+	// before a delimiter shows up means the body was cut short.
+	peek, err := p.br.Peek(p.br.Size())
+	if i := bytes.Index(peek, p.delimiter); i >= 0 {
+		if i == 0 {
+			return 0, io.EOF
+		}
+		peek = peek[:i]
+	} else if err != nil && err != bufio.ErrBufferFull {
+		return 0, unexpectedEOF(err)
+	} else {
+		// The tail of the buffer may be the start of a delimiter; hold it
+		// back until more has been read.
+		peek = peek[:len(peek)-len(p.delimiter)+1]
+	}
+	n := copy(b, peek)
+	p.br.Discard(n)
+	return n, nil
+}
+
+// unexpectedEOF reports a stream that ended in the middle of the form.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("%w: body ended before the closing boundary", ErrMalformedForm)
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"HttpFromTcp/internal/headers"
+)
+
+// ErrBodyClosed is returned when reading a request body after Close.
+var ErrBodyClosed = errors.New("read on closed request body")
+
+// Reader reads consecutive requests from a single connection. Bytes that
+// arrive after the end of one request (e.g. a pipelined second request) are
+// kept and handed to the next call to ReadRequest instead of being dropped.
+type Reader struct {
+	// Limits caps the size of every request read from now on. The zero
+	// value means no limits.
+	Limits Limits
+
+	reader io.Reader
+	buffer []byte
+	tmp    []byte
+}
+
+// NewReader creates a Reader that pulls request bytes from reader.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		tmp:    make([]byte, 1024),
+	}
+}
+
+// ReadRequest parses the next request from the connection, body included.
+// It returns io.EOF if the stream ends cleanly before the request starts.
+func (cr *Reader) ReadRequest() (*Request, error) {
+	req, err := cr.readRequest(false)
+	if err != nil {
+		return nil, err
+	}
+	for req.state != StateDone {
+		if err := cr.advance(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// ReadRequestHeaders parses the request line and headers of the next
+// request and returns without waiting for the body. The body must be read
+// through req.BodyReader, and read to the end (or closed) before the next
+// request on the connection can be read. Body stays nil.
+// It returns io.EOF if the stream ends cleanly before the request starts.
+func (cr *Reader) ReadRequestHeaders() (*Request, error) {
+	return cr.readRequest(true)
+}
+
+// ReadBody reads the rest of a body left on the connection by
+// ReadRequestHeaders into Body, so the request looks like one returned by
+// ReadRequest. It does nothing for requests whose body is already buffered.
+func (r *Request) ReadBody() error {
+	if r.body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(r.body)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	r.body = nil
+	return nil
+}
+
+// BodyDone reports whether the whole request, body included, has been read
+// off the connection, so that the next request on it can be read.
+func (r *Request) BodyDone() bool {
+	return r.state == StateDone
+}
+
+// readRequest parses until the headers are done and the body framing is known.
+func (cr *Reader) readRequest(stream bool) (*Request, error) {
+	req := &Request{
+		state:    StateRequestLine,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   cr.Limits,
+	}
+	if stream {
+		req.body = &bodyReader{cr: cr, req: req}
+	}
+	for req.state < StateBody {
+		if err := cr.advance(req); err != nil {
+			// The peer closed the connection before sending anything, which
+			// is how a keep-alive client says it is done. Report it as a
+			// plain EOF so callers can tell it apart from a truncated request.
+			// (A partial request line would still be sitting in the buffer.)
+			if errors.Is(err, ErrIncompleteRequest) && req.state == StateRequestLine && len(cr.buffer) == 0 {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// advance moves the parser forward: it parses whatever is buffered and, if
+// that doesn't finish the current step, reads more from the connection.
+func (cr *Reader) advance(req *Request) error {
+	// Step 1: Parse whatever we already have. Leftovers from the previous
+	// request may hold this one in full, so this happens before any read.
+	stateBefore, bodyBefore := req.state, req.bodyRead
+	bytesConsumed, err := req.parse(cr.buffer)
+	if err != nil {
+		return err
+	}
+	cr.buffer = cr.buffer[bytesConsumed:]
+	if req.state != stateBefore || req.bodyRead != bodyBefore || bytesConsumed > 0 {
+		return nil
+	}
+
+	// Step 2: Ask the connection for more bytes.
+	n, err := cr.reader.Read(cr.tmp)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read from reader: %w", err)
+	}
+	if n > 0 {
+		// Parse the new bytes before reacting to EOF; the final read may
+		// carry the end of the request together with the EOF.
+		cr.buffer = append(cr.buffer, cr.tmp[:n]...)
+		return nil
+	}
+	if err == io.EOF {
+		return ErrIncompleteRequest
+	}
+	return nil
+}
+
+// bodyReader streams the body of a request read with ReadRequestHeaders.
+// It drives the same parser as ReadRequest, so Content-Length and chunked
+// bodies are decoded the same way.
+type bodyReader struct {
+	cr     *Reader
+	req    *Request
+	closed bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	for len(b.req.pending) == 0 {
+		if b.req.state == StateDone {
+			return 0, io.EOF
+		}
+		if hook := b.req.beforeBodyRead; hook != nil {
+			b.req.beforeBodyRead = nil
+			if err := hook(); err != nil {
+				return 0, err
+			}
+		}
+		if err := b.cr.advance(b.req); err != nil {
+			if errors.Is(err, ErrIncompleteRequest) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, b.req.pending)
+	b.req.pending = b.req.pending[n:]
+	return n, nil
+}
+
+// Close reads and discards the rest of the body so that the connection is
+// positioned at the start of the next request.
+func (b *bodyReader) Close() error {
+	if b.closed {
+		return nil
+	}
+	_, err := io.Copy(io.Discard, b)
+	b.closed = true
+	return err
+}
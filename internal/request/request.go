@@ -2,10 +2,10 @@ package request
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	"HttpFromTcp/internal/headers"
 )
@@ -14,85 +14,185 @@ const (
 	StateRequestLine = iota
 	StateHeaders
 	StateBody
+	StateChunkSize
+	StateChunkData
+	StateChunkDataEnd
+	StateTrailers
 	StateDone
 )
 
+// maxEmptyLines is how many empty lines may come before a request line.
+// RFC 9112, Section 2.2 asks servers to skip at least one, since some
+// clients send an extra CRLF after a request body.
+const maxEmptyLines = 4
+
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	state       int
+	// Target is the parsed request-target: its form, path and query.
+	Target  Target
+	Headers *headers.Headers
+	Body    []byte
+	// Trailers holds the fields sent after a chunked body. It is kept apart
+	// from Headers because it only becomes known once the body is read.
+	Trailers *headers.Headers
+	state    int
+
+	// Form holds the parsed form values from both the body and the query
+	// string. PostForm holds only the body values, and MultipartForm the
+	// fields and files of a multipart body. All three are nil until
+	// ParseForm or ParseMultipartForm is called.
+	Form          Query
+	PostForm      Query
+	MultipartForm *MultipartForm
+
+	// chunkRemaining is how many bytes of the current chunk are still to come.
+	chunkRemaining int64
+	// contentLength is the body length announced by Content-Length.
+	contentLength int
+	// bodyRead counts the decoded body bytes parsed so far.
+	bodyRead int
+
+	// limits caps the size of each part of the request; fieldBytes and
+	// fieldCount measure the header or trailer section being parsed.
+	limits     Limits
+	fieldBytes int
+	fieldCount int
+
+	// body is set for requests read with ReadRequestHeaders. Decoded body
+	// bytes then collect in pending until the handler reads them, instead
+	// of piling up in Body.
+	body    *bodyReader
+	pending []byte
+
+	// emptyLines counts the empty lines skipped before the request line.
+	emptyLines int
+
+	// pathValues holds the path parameters matched by a router.
+	pathValues map[string]string
+	// id is the request ID assigned by middleware. See SetID.
+	id string
+	// beforeBodyRead runs once, before a streamed body is first read from
+	// the connection. See BeforeBodyRead.
+	beforeBodyRead func() error
 }
 
 type RequestLine struct {
-	HttpVersion   string
+	HttpVersion string
+	// RequestTarget is the target exactly as sent; Request.Target holds
+	// the parsed version.
 	RequestTarget string
 	Method        string
 }
 
+// RequestFromReader parses a single request from reader. Any bytes that follow
+// the request are discarded; use a Reader to read several requests from the
+// same connection.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	req := &Request{
-		state:   StateRequestLine,
-		Headers: headers.NewHeaders(),
+	return NewReader(reader).ReadRequest()
+}
+
+// BodyReader returns the request body as a stream. For requests read with
+// ReadRequestHeaders the bytes come straight off the connection, framed by
+// Content-Length or chunked encoding; otherwise it reads from Body.
+func (r *Request) BodyReader() io.ReadCloser {
+	if r.body != nil {
+		return r.body
 	}
-	var buffer []byte
-	tmp := make([]byte, 1024)
-	for req.state != StateDone {
-		n, err := reader.Read(tmp)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read from reader: %w", err)
-		}
-		if n > 0 {
-			buffer = append(buffer, tmp[:n]...)
-		}
-		bytesConsumed, parseErr := req.parse(buffer)
-		if parseErr != nil {
-			return nil, parseErr
-		}
-		buffer = buffer[bytesConsumed:]
-		
-		// FIX: Check if we're done BEFORE checking EOF
-		// This handles the case where we finished parsing but haven't hit EOF yet
-		if req.state == StateDone {
-			break
-		}
-		
-		if err == io.EOF {
-			if req.state != StateDone {
-				return nil, errors.New("incomplete request: stream ended before request was fully parsed")
-			}
-			break
-		}
+	return io.NopCloser(bytes.NewReader(r.Body))
+}
+
+// ExpectsContinue reports whether the client is holding back its body until
+// the server answers "100 Continue": it sent "Expect: 100-continue" and the
+// body hasn't arrived yet.
+func (r *Request) ExpectsContinue() bool {
+	return r.state != StateDone && strings.EqualFold(r.Headers.Get("Expect"), "100-continue")
+}
+
+// BeforeBodyRead registers fn to run once, right before the body of a
+// request read with ReadRequestHeaders is first read from the connection.
+// Servers use it to send "100 Continue" only when the handler asks for the
+// body. If fn fails, the read returns its error.
+func (r *Request) BeforeBodyRead(fn func() error) {
+	r.beforeBodyRead = fn
+}
+
+// PathValue returns the value of the named path parameter matched by the
+// router, or "" if there is no such parameter.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue records a path parameter so handlers can read it with
+// PathValue. Routers call it when they match a route.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
 	}
-	return req, nil
+	r.pathValues[name] = value
+}
+
+// ID returns the ID given to the request with SetID, or "" if it has none.
+func (r *Request) ID() string {
+	return r.id
+}
+
+// SetID gives the request an ID, e.g. for correlating log lines. Request
+// ID middleware calls it; the request's headers are left as the client
+// sent them.
+func (r *Request) SetID(id string) {
+	r.id = id
+}
+
+// appendBody stores decoded body bytes where the reader of the body will
+// look for them.
+func (r *Request) appendBody(data []byte) {
+	r.bodyRead += len(data)
+	if r.body != nil {
+		r.pending = append(r.pending, data...)
+		return
+	}
+	r.Body = append(r.Body, data...)
 }
 
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.state != StateDone {
-		if len(data[totalBytesParsed:]) == 0 {
-			break
-		}
+		// Empty data is still passed through so that a step which needs no
+		// bytes can finish without waiting for more from the client.
+		stateBefore := r.state
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
 		}
-		// FIX: Check if state is done BEFORE checking n == 0
+		totalBytesParsed += n
+		// Check if state is done BEFORE checking n == 0
 		// This handles the case where we transition to StateDone but return 0 bytes
 		if r.state == StateDone {
-			return totalBytesParsed, nil
+			break
 		}
-		if n == 0 {
+		// Only stop for more data if nothing happened; switching state without
+		// consuming bytes (e.g. picking the body framing) is still progress.
+		if n == 0 && r.state == stateBefore {
 			break
 		}
-		totalBytesParsed += n
 	}
 	return totalBytesParsed, nil
 }
 
 func (r *Request) parseSingle(data []byte) (int, error) {
-	// Step 1: Parse the request line.
+	// Step 1: Parse the request line, skipping the few empty lines a
+	// client may send before it.
 	if r.state == StateRequestLine {
+		if bytes.HasPrefix(data, []byte("\r\n")) {
+			if r.emptyLines == maxEmptyLines {
+				return 0, fmt.Errorf("%w: more than %d empty lines before the request line", ErrMalformedRequestLine, maxEmptyLines)
+			}
+			r.emptyLines++
+			return 2, nil
+		}
+		if err := r.checkRequestLine(data); err != nil {
+			return 0, err
+		}
 		rl, bytesConsumed, err := parseRequestLine(data)
 		if err != nil {
 			return 0, err
@@ -100,7 +200,12 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if bytesConsumed == 0 {
 			return 0, nil
 		}
+		target, err := ParseTarget(rl.Method, rl.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = rl
+		r.Target = target
 		r.state = StateHeaders
 		return bytesConsumed, nil
 	}
@@ -111,59 +216,155 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if err := r.checkFieldSection(data, n, done); err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			r.fieldBytes += n
+		}
 		if done {
-			r.state = StateBody
+			// The trailer section gets its own allowance.
+			r.fieldBytes, r.fieldCount = 0, 0
+			if err := r.checkExpect(); err != nil {
+				return 0, err
+			}
+			// The headers decide how the body is framed, so check them now
+			// rather than when the body is first read.
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
 		}
 		return n, nil
 	}
 
-	// Step 3: Parse the body.
+	// Step 3: Parse a Content-Length body.
 	if r.state == StateBody {
-		// Get the Content-Length to know how many bytes to read.
-		contentLengthStr := r.Headers.Get("Content-Length")
-		if contentLengthStr == "" {
-			// If no Content-Length, assume no body and we're done.
-			r.state = StateDone
-			return 0, nil
-		}
-
-		contentLength, err := strconv.Atoi(contentLengthStr)
-		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length header: %w", err)
-		}
-
-		if contentLength == 0 {
-			r.state = StateDone
-			return 0, nil
-		}
+		contentLength := r.contentLength
 
 		// Figure out how many bytes to consume from the current data chunk.
-		bytesNeeded := contentLength - len(r.Body)
+		bytesNeeded := contentLength - r.bodyRead
 		bytesToConsume := len(data)
 		if bytesToConsume > bytesNeeded {
 			bytesToConsume = bytesNeeded
 		}
 
 		// Append the consumed bytes to the body.
-		r.Body = append(r.Body, data[:bytesToConsume]...)
+		r.appendBody(data[:bytesToConsume])
 
 		// Check if we've read the entire body.
-		if len(r.Body) == contentLength {
+		if r.bodyRead == contentLength {
 			r.state = StateDone
 		}
 
 		// Check if we've read too much (shouldn't happen with the logic above, but good practice).
-		if len(r.Body) > contentLength {
+		if r.bodyRead > contentLength {
 			return 0, fmt.Errorf("body is longer than Content-Length")
 		}
 
 		return bytesToConsume, nil
 	}
 
+	// Step 4: Decode a chunked body.
+	if r.state >= StateChunkSize && r.state <= StateTrailers {
+		return r.parseChunked(data)
+	}
+
 	return 0, nil
 }
 
+// startBody picks the state that follows the headers based on how the body
+// is framed: chunked, a Content-Length, or no body at all.
+//
+// The framing rules follow RFC 9112, Section 6. Anything a proxy in front
+// of us might read differently is rejected instead of guessed at, since a
+// disagreement over where a body ends lets a client smuggle a second
+// request inside the first.
+func (r *Request) startBody() error {
+	// Step 1: Refuse requests that announce both kinds of framing.
+	hasTE := r.Headers.Has("Transfer-Encoding")
+	hasCL := r.Headers.Has("Content-Length")
+	if hasTE && hasCL {
+		return fmt.Errorf("%w: both Content-Length and Transfer-Encoding are set", ErrAmbiguousFraming)
+	}
+
+	// Step 2: A chunked body. HTTP/1.0 has no Transfer-Encoding, so a 1.0
+	// request carrying one was either mangled or crafted.
+	if hasTE {
+		if r.RequestLine.HttpVersion == "1.0" {
+			return fmt.Errorf("%w: Transfer-Encoding in an HTTP/1.0 request", ErrAmbiguousFraming)
+		}
+		if err := checkTransferEncoding(r.Headers.Values("Transfer-Encoding")); err != nil {
+			return err
+		}
+		r.state = StateChunkSize
+		return nil
+	}
+
+	// Step 3: Get the Content-Length to know how many bytes to read.
+	if !hasCL {
+		// If no Content-Length, assume no body and we're done.
+		r.state = StateDone
+		return nil
+	}
+	contentLength, err := parseContentLength(r.Headers.Values("Content-Length"))
+	if err != nil {
+		return err
+	}
+
+	if contentLength == 0 {
+		r.state = StateDone
+		return nil
+	}
+	// Refuse an oversized body before any of it is read.
+	if err := r.checkBodySize(contentLength); err != nil {
+		return err
+	}
+	r.contentLength = contentLength
+	r.state = StateBody
+	return nil
+}
+
+// checkExpect refuses expectations the server can't meet. 100-continue is
+// the only one defined (RFC 9110, Section 10.1.1); the server answers it
+// when the body is wanted.
+func (r *Request) checkExpect() error {
+	expect := r.Headers.Get("Expect")
+	if expect == "" || strings.EqualFold(expect, "100-continue") {
+		return nil
+	}
+	return fmt.Errorf("%w: '%s'", ErrExpectationFailed, expect)
+}
+
+// parseContentLength reads the body length from every Content-Length
+// field. Each is 1*DIGIT, or a list of them from a proxy that merged
+// repeated fields; all of them must agree (RFC 9112, Section 6.3).
+func parseContentLength(values []string) (int, error) {
+	length := -1
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			element = strings.Trim(element, " \t")
+			// Atoi would also take a sign, and "" would mean no body to one
+			// parser and a zero-length one to another.
+			if element == "" || strings.TrimLeft(element, "0123456789") != "" {
+				return 0, fmt.Errorf("%w: '%s'", ErrInvalidContentLength, value)
+			}
+			n, err := strconv.Atoi(element)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %v", ErrInvalidContentLength, err)
+			}
+			if length != -1 && n != length {
+				return 0, fmt.Errorf("%w: conflicting values %d and %d", ErrInvalidContentLength, length, n)
+			}
+			length = n
+		}
+	}
+	return length, nil
+}
+
 func parseRequestLine(data []byte) (RequestLine, int, error) {
+	if hasBareLF(data) {
+		return RequestLine{}, 0, fmt.Errorf("%w: line ends in LF without CR", ErrMalformedRequestLine)
+	}
 	crlfIndex := bytes.Index(data, []byte("\r\n"))
 	if crlfIndex == -1 {
 		return RequestLine{}, 0, nil
@@ -172,25 +373,22 @@ func parseRequestLine(data []byte) (RequestLine, int, error) {
 	bytesConsumed := crlfIndex + 2
 	parts := bytes.Split(lineBytes, []byte(" "))
 	if len(parts) != 3 {
-		return RequestLine{}, 0, fmt.Errorf("invalid request line: expected 3 parts, got %d", len(parts))
+		return RequestLine{}, 0, fmt.Errorf("%w: expected 3 parts, got %d", ErrMalformedRequestLine, len(parts))
 	}
 
 	method, target, versionRaw := parts[0], parts[1], parts[2]
 
 	for _, char := range method {
 		if char < 'A' || char > 'Z' {
-			return RequestLine{}, 0, fmt.Errorf("invalid method '%s': must be all uppercase", string(method))
+			return RequestLine{}, 0, fmt.Errorf("%w '%s': must be all uppercase", ErrInvalidMethod, string(method))
 		}
 	}
-	versionStr := string(versionRaw)
-	var httpVersion string
-	switch versionStr {
-	case "HTTP/1.1":
-		httpVersion = "1.1"
-	case "HTTP/1.0":
-		httpVersion = "1.0"
-	default:
-		return RequestLine{}, 0, fmt.Errorf("invalid http version '%s': only HTTP/1.1 and HTTP/1.0 are supported", versionStr)
+	if !knownMethods[string(method)] {
+		return RequestLine{}, 0, fmt.Errorf("%w: '%s'", ErrMethodNotImplemented, string(method))
+	}
+	httpVersion, err := parseHTTPVersion(string(versionRaw))
+	if err != nil {
+		return RequestLine{}, 0, err
 	}
 
 	rl := RequestLine{
@@ -199,4 +397,48 @@ func parseRequestLine(data []byte) (RequestLine, int, error) {
 		HttpVersion:   httpVersion,
 	}
 	return rl, bytesConsumed, nil
-}
\ No newline at end of file
+}
+
+// knownMethods are the methods defined by RFC 9110 (plus PATCH). Anything
+// else is a well-formed request the server can't handle.
+var knownMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+}
+
+// parseHTTPVersion turns "HTTP/1.1" into "1.1". A version that isn't of the
+// form HTTP/x.y is malformed; one that is but isn't 1.0 or 1.1 is
+// unsupported.
+func parseHTTPVersion(versionStr string) (string, error) {
+	switch versionStr {
+	case "HTTP/1.1":
+		return "1.1", nil
+	case "HTTP/1.0":
+		return "1.0", nil
+	}
+	v := []byte(versionStr)
+	if len(v) == len("HTTP/x.y") && bytes.HasPrefix(v, []byte("HTTP/")) &&
+		isDigit(v[5]) && v[6] == '.' && isDigit(v[7]) {
+		return "", fmt.Errorf("%w '%s': only HTTP/1.1 and HTTP/1.0 are supported", ErrUnsupportedVersion, versionStr)
+	}
+	return "", fmt.Errorf("%w: invalid http version '%s'", ErrMalformedRequestLine, versionStr)
+}
+
+// hasBareLF reports whether the first LF in data isn't preceded by a CR.
+// Lines must end in CRLF; accepting a lone LF as well would let a request
+// be split into lines differently than a stricter proxy in front of us did.
+func hasBareLF(data []byte) bool {
+	lf := bytes.IndexByte(data, '\n')
+	return lf != -1 && (lf == 0 || data[lf-1] != '\r')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -2,8 +2,11 @@ package request
 
 import (
 	"io"
+	"strings"
 	"testing"
 
+	"HttpFromTcp/internal/headers"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -137,4 +140,474 @@ func TestRequestFromReader_BodyParsing(t *testing.T) {
 		require.NotNil(t, r)
 		assert.Empty(t, r.Body)
 	})
-}
\ No newline at end of file
+}
+
+// This function tests how the end of the stream is reported.
+func TestRequestFromReader_EndOfStream(t *testing.T) {
+	t.Run("Closed before any bytes", func(t *testing.T) {
+		reader := &chunkReader{data: "", numBytesPerRead: 5}
+		_, err := RequestFromReader(reader)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Closed in the middle of the request line", func(t *testing.T) {
+		reader := &chunkReader{data: "GET / HT", numBytesPerRead: 5}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, io.EOF)
+		assert.Contains(t, err.Error(), "incomplete request")
+	})
+}
+
+// This function tests reading several requests from one connection.
+func TestReader_Pipelining(t *testing.T) {
+	t.Run("Two requests in a single read", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "GET /first HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+				"GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			numBytesPerRead: 1024,
+		})
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+		_, err = reader.ReadRequest()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Body bytes are not handed to the next request", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+				"GET /next HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 7,
+		})
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "GET", r.RequestLine.Method)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("Empty lines before a request are skipped", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /submit HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi\r\n" +
+				"\r\nGET /next HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 1,
+		})
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "hi", string(r.Body))
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("Too many empty lines are rejected", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data:            strings.Repeat("\r\n", maxEmptyLines+1) + "GET / HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 1024,
+		})
+		_, err := reader.ReadRequest()
+		assert.ErrorIs(t, err, ErrMalformedRequestLine)
+	})
+
+	t.Run("Second request cut short", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data:            "GET /first HTTP/1.1\r\n\r\nGET /sec",
+			numBytesPerRead: 1024,
+		})
+		_, err := reader.ReadRequest()
+		require.NoError(t, err)
+
+		_, err = reader.ReadRequest()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "incomplete request")
+	})
+}
+
+// This function tests decoding of Transfer-Encoding: chunked bodies.
+func TestRequestFromReader_ChunkedBody(t *testing.T) {
+	t.Run("Standard chunked body", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n" +
+				"8\r\n, world!\r\n" +
+				"0\r\n" +
+				"\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "hello, world!", string(r.Body))
+	})
+
+	t.Run("Chunk extensions and trailers", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"A;name=value\r\n0123456789\r\n" +
+				"1 ; last\r\n!\r\n" +
+				"0\r\n" +
+				"Digest: sha-256=abc\r\n" +
+				"\r\n",
+			numBytesPerRead: 4,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789!", string(r.Body))
+		assert.Equal(t, "sha-256=abc", r.Trailers.Get("digest"))
+		assert.Empty(t, r.Headers.Get("digest"))
+	})
+
+	t.Run("Chunked is the final coding, next request follows", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: gzip, chunked\r\n" +
+				"\r\n" +
+				"3\r\nabc\r\n0\r\n\r\n" +
+				"GET /next HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 1024,
+		})
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(r.Body))
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("Invalid chunk size", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"+5\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 8,
+		}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid chunk size")
+	})
+
+	t.Run("Chunk data longer than its size", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"3\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 8,
+		}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing CRLF after chunk data")
+	})
+
+	t.Run("Stream ends before the last chunk", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n",
+			numBytesPerRead: 8,
+		}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "incomplete request")
+	})
+
+	t.Run("Unsupported transfer coding", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: gzip\r\n" +
+				"\r\n",
+			numBytesPerRead: 8,
+		}
+		_, err := RequestFromReader(reader)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported Transfer-Encoding")
+	})
+}
+
+// This function tests reading bodies as a stream.
+func TestReader_StreamingBody(t *testing.T) {
+	t.Run("Content-Length body", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Content-Length: 26\r\n" +
+				"\r\n" +
+				"abcdefghijklmnopqrstuvwxyz" +
+				"GET /next HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 4,
+		})
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+		assert.Nil(t, r.Body)
+
+		body, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, "abcdefghijklmnopqrstuvwxyz", string(body))
+		assert.Nil(t, r.Body)
+
+		r, err = reader.ReadRequestHeaders()
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("Chunked body with trailers", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"4\r\nWiki\r\n5\r\npedia\r\n0\r\nDigest: abc\r\n\r\n",
+			numBytesPerRead: 3,
+		})
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, "Wikipedia", string(body))
+		assert.Equal(t, "abc", r.Trailers.Get("digest"))
+	})
+
+	t.Run("Close skips the unread body", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Content-Length: 11\r\n" +
+				"\r\n" +
+				"hello world" +
+				"GET /next HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 5,
+		})
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+
+		body := r.BodyReader()
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(body, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hel", string(buf))
+		assert.False(t, r.BodyDone())
+		require.NoError(t, body.Close())
+		assert.True(t, r.BodyDone())
+		_, err = body.Read(buf)
+		assert.ErrorIs(t, err, ErrBodyClosed)
+
+		r, err = reader.ReadRequestHeaders()
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("Body cut short", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Content-Length: 20\r\n" +
+				"\r\n" +
+				"partial",
+			numBytesPerRead: 5,
+		})
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+
+		_, err = io.ReadAll(r.BodyReader())
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("Buffered request still has a body reader", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nbody",
+			numBytesPerRead: 5,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, "body", string(body))
+	})
+}
+
+func TestRequest_ReadBody(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.Nil(t, r.Body)
+
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "abcdef", string(r.Body))
+
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(body))
+}
+
+// This function tests the size limits on each part of a request.
+func TestReader_Limits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        10,
+	}
+	read := func(data string) (*Request, error) {
+		reader := NewReader(&chunkReader{data: data, numBytesPerRead: 7})
+		reader.Limits = limits
+		return reader.ReadRequest()
+	}
+
+	t.Run("Within limits", func(t *testing.T) {
+		r, err := read("POST /ok HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n0123456789")
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(r.Body))
+	})
+
+	t.Run("Request line too long", func(t *testing.T) {
+		_, err := read("GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n")
+		assert.ErrorIs(t, err, ErrRequestLineTooLong)
+	})
+
+	t.Run("Request line too long without CRLF yet", func(t *testing.T) {
+		_, err := read("GET /" + strings.Repeat("a", 1000))
+		assert.ErrorIs(t, err, ErrRequestLineTooLong)
+	})
+
+	t.Run("Too many header bytes", func(t *testing.T) {
+		_, err := read("GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("v", 100) + "\r\n\r\n")
+		assert.ErrorIs(t, err, ErrHeadersTooLarge)
+	})
+
+	t.Run("Too many header fields", func(t *testing.T) {
+		_, err := read("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+		assert.ErrorIs(t, err, ErrHeadersTooLarge)
+	})
+
+	t.Run("Content-Length over the limit", func(t *testing.T) {
+		_, err := read("POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\n")
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+
+	t.Run("Chunked body over the limit", func(t *testing.T) {
+		_, err := read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"6\r\nabcdef\r\n6\r\nghijkl\r\n0\r\n\r\n")
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+
+	t.Run("Too many trailer fields", func(t *testing.T) {
+		_, err := read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"0\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+		assert.ErrorIs(t, err, ErrHeadersTooLarge)
+	})
+
+	t.Run("No limits by default", func(t *testing.T) {
+		r, err := RequestFromReader(&chunkReader{
+			data:            "GET /" + strings.Repeat("a", 10000) + " HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 1024,
+		})
+		require.NoError(t, err)
+		assert.Len(t, r.RequestLine.RequestTarget, 10001)
+	})
+}
+
+func TestRequestFromReader_ParseErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		request string
+		target  error
+		status  int
+	}{
+		{"Missing request line part", "GET /\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"Lowercase method", "get / HTTP/1.1\r\n\r\n", ErrInvalidMethod, 400},
+		{"Unknown method", "BREW /pot HTTP/1.1\r\n\r\n", ErrMethodNotImplemented, 501},
+		{"Unsupported version", "GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion, 505},
+		{"Malformed version", "GET / HTTX/1.1\r\n\r\n", ErrMalformedRequestLine, 400},
+		{"Invalid Content-Length", "POST / HTTP/1.1\r\nContent-Length: ten\r\n\r\n", ErrInvalidContentLength, 400},
+		{"Negative Content-Length", "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", ErrInvalidContentLength, 400},
+		{"Unsupported transfer coding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", ErrUnsupportedTransferEncoding, 501},
+		{"Bad chunk size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", ErrMalformedChunk, 400},
+		{"Truncated request", "GET / HTTP/1.1\r\nHost: x", ErrIncompleteRequest, 400},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := RequestFromReader(strings.NewReader(tc.request))
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tc.target)
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tc.status, parseErr.Status)
+		})
+	}
+
+	t.Run("Header errors come from the headers package", func(t *testing.T) {
+		_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost : x\r\n\r\n"))
+		assert.ErrorIs(t, err, headers.ErrSpaceBeforeColon)
+	})
+}
+
+func TestRequest_ExpectContinue(t *testing.T) {
+	t.Run("Waiting for 100 Continue", func(t *testing.T) {
+		// One byte per read leaves the body on the connection.
+		reader := NewReader(&chunkReader{
+			data:            "POST / HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-Continue\r\n\r\nhello",
+			numBytesPerRead: 1,
+		})
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+		assert.True(t, r.ExpectsContinue())
+
+		calls := 0
+		r.BeforeBodyRead(func() error {
+			calls++
+			return nil
+		})
+		body, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.Equal(t, 1, calls)
+		assert.False(t, r.ExpectsContinue())
+	})
+
+	t.Run("Body already here", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello"))
+		require.NoError(t, err)
+		assert.False(t, r.ExpectsContinue())
+	})
+
+	t.Run("Hook error fails the read", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data:            "POST / HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello",
+			numBytesPerRead: 1,
+		})
+		r, err := reader.ReadRequestHeaders()
+		require.NoError(t, err)
+		r.BeforeBodyRead(func() error { return io.ErrClosedPipe })
+		_, err = io.ReadAll(r.BodyReader())
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+
+	t.Run("Unknown expectation", func(t *testing.T) {
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nExpect: 200-ok\r\n\r\n"))
+		assert.ErrorIs(t, err, ErrExpectationFailed)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, 417, parseErr.Status)
+	})
+}
@@ -0,0 +1,201 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"HttpFromTcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statusOf returns the status code carried by a parse error, whether it
+// came from this package or the headers package.
+func statusOf(t *testing.T, err error) int {
+	t.Helper()
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	return parseErr.Status
+}
+
+// TestRequestFromReader_Smuggling feeds the parser payloads used to smuggle
+// a request past a proxy that frames the body differently (RFC 9112,
+// Section 6 and Section 11.2). Every one must be refused before any body
+// is read, so nothing after the headers can be taken as a second request.
+func TestRequestFromReader_Smuggling(t *testing.T) {
+	cases := []struct {
+		name    string
+		request string
+		target  error
+		status  int
+	}{
+		{
+			"CL.TE",
+			"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG",
+			ErrAmbiguousFraming, 400,
+		},
+		{
+			"TE.CL",
+			"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n",
+			ErrAmbiguousFraming, 400,
+		},
+		{
+			"TE.TE with an obfuscated second coding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: x\r\n\r\n",
+			ErrInvalidTransferEncoding, 400,
+		},
+		{
+			"Conflicting Content-Length fields",
+			"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+			ErrInvalidContentLength, 400,
+		},
+		{
+			"Conflicting Content-Length list",
+			"POST / HTTP/1.1\r\nContent-Length: 5, 6\r\n\r\nhello!",
+			ErrInvalidContentLength, 400,
+		},
+		{
+			"Empty Content-Length",
+			"POST / HTTP/1.1\r\nContent-Length: \r\n\r\nGET /admin HTTP/1.1\r\n\r\n",
+			ErrInvalidContentLength, 400,
+		},
+		{
+			"Signed Content-Length",
+			"POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\nhello",
+			ErrInvalidContentLength, 400,
+		},
+		{
+			"Hex Content-Length",
+			"POST / HTTP/1.1\r\nContent-Length: 0x5\r\n\r\nhello",
+			ErrInvalidContentLength, 400,
+		},
+		{
+			"Space before the colon",
+			"POST / HTTP/1.1\r\nContent-Length : 5\r\n\r\nhello",
+			headers.ErrSpaceBeforeColon, 400,
+		},
+		{
+			"Tab before the colon",
+			"POST / HTTP/1.1\r\nTransfer-Encoding\t: chunked\r\n\r\n0\r\n\r\n",
+			headers.ErrSpaceBeforeColon, 400,
+		},
+		{
+			"Obs-fold continuing Transfer-Encoding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: gzip,\r\n chunked\r\n\r\n",
+			headers.ErrObsFold, 400,
+		},
+		{
+			"Whitespace before the first field",
+			"POST / HTTP/1.1\r\n Transfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello",
+			headers.ErrObsFold, 400,
+		},
+		{
+			"Bare LF between fields",
+			"POST / HTTP/1.1\r\nX-Pad: a\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			headers.ErrBareLF, 400,
+		},
+		{
+			"Bare LF at the end of the headers",
+			"POST / HTTP/1.1\r\nContent-Length: 5\r\n\nhello",
+			headers.ErrBareLF, 400,
+		},
+		{
+			"Bare CR inside a value",
+			"POST / HTTP/1.1\r\nX-Pad: a\rTransfer-Encoding: chunked\r\n\r\n",
+			headers.ErrInvalidFieldValue, 400,
+		},
+		{
+			"NUL inside a value",
+			"GET / HTTP/1.1\r\nHost: a\x00b\r\n\r\n",
+			headers.ErrInvalidFieldValue, 400,
+		},
+		{
+			"Bare LF after the request line",
+			"GET / HTTP/1.1\nHost: x\r\n\r\n",
+			ErrMalformedRequestLine, 400,
+		},
+		{
+			"Chunked is not the final coding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\n\r\n",
+			ErrInvalidTransferEncoding, 400,
+		},
+		{
+			"Chunked applied twice",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked, chunked\r\n\r\n",
+			ErrInvalidTransferEncoding, 400,
+		},
+		{
+			"Empty Transfer-Encoding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: \r\n\r\n",
+			ErrInvalidTransferEncoding, 400,
+		},
+		{
+			"Lookalike coding",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: xchunked\r\n\r\n",
+			ErrUnsupportedTransferEncoding, 501,
+		},
+		{
+			"Transfer-Encoding in HTTP/1.0",
+			"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			ErrAmbiguousFraming, 400,
+		},
+		{
+			"Bare LF after the chunk size",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n",
+			ErrMalformedChunk, 400,
+		},
+		{
+			"Bare CR in a chunk extension",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;a\rb\r\nhello\r\n0\r\n\r\n",
+			ErrMalformedChunk, 400,
+		},
+		{
+			"Bare LF in the trailers",
+			"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Trailer: a\n\r\n",
+			headers.ErrBareLF, 400,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Byte-at-a-time reads catch checks that only work when a whole
+			// line arrives at once.
+			for _, perRead := range []int{1, 1024} {
+				_, err := RequestFromReader(&chunkReader{data: tc.request, numBytesPerRead: perRead})
+				require.Error(t, err)
+				assert.ErrorIs(t, err, tc.target)
+				assert.Equal(t, tc.status, statusOf(t, err))
+			}
+		})
+	}
+}
+
+func TestRequestFromReader_UnambiguousFraming(t *testing.T) {
+	t.Run("Repeated identical Content-Length", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader(
+			"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	})
+
+	t.Run("Identical Content-Length list", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader(
+			"POST / HTTP/1.1\r\nContent-Length: 5 ,5\r\n\r\nhello"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	})
+
+	t.Run("Codings split over several fields", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader(
+			"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\nTransfer-Encoding: Chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(r.Body))
+	})
+
+	t.Run("Tabs around a value are optional whitespace", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader(
+			"POST / HTTP/1.1\r\nContent-Length:\t2\t\r\n\r\nhi"))
+		require.NoError(t, err)
+		assert.Equal(t, "hi", string(r.Body))
+	})
+}
@@ -0,0 +1,320 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is one of the four shapes a request-target can take
+// (RFC 9112, Section 3.2).
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path and optional query, e.g.
+	// "/where?q=now". It is what almost every request uses.
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URI, e.g. "http://www.example.org/pub". It is
+	// sent to proxies, and servers must accept it too.
+	AbsoluteForm
+	// AuthorityForm is "host:port" and is only used with CONNECT.
+	AuthorityForm
+	// AsteriskForm is "*" and is only used with OPTIONS, to ask about the
+	// server as a whole.
+	AsteriskForm
+)
+
+// String returns the RFC name of the form, e.g. "origin-form".
+func (f TargetForm) String() string {
+	switch f {
+	case OriginForm:
+		return "origin-form"
+	case AbsoluteForm:
+		return "absolute-form"
+	case AuthorityForm:
+		return "authority-form"
+	case AsteriskForm:
+		return "asterisk-form"
+	}
+	return fmt.Sprintf("TargetForm(%d)", int(f))
+}
+
+// Target is the parsed request-target of a request.
+type Target struct {
+	Form TargetForm
+	// Scheme is the lowercased scheme of an absolute-form target.
+	Scheme string
+	// Host is the host (and port, if given) of an absolute-form or
+	// authority-form target.
+	Host string
+	// Path is the percent-decoded path with "." and ".." segments removed.
+	// It is "/" for an absolute-form target without a path, and empty for
+	// the authority and asterisk forms.
+	Path string
+	// RawPath is the path exactly as sent, before decoding.
+	RawPath string
+	// RawQuery is the query without the leading "?", still encoded.
+	RawQuery string
+	// Query is the decoded query.
+	Query Query
+	// Fragment is the decoded fragment. Clients aren't supposed to send
+	// one, but some do; it plays no part in routing.
+	Fragment string
+}
+
+// Query maps query parameter names to their values, in the order they
+// appeared. "a=1&a=2&b" gives {"a": ["1", "2"], "b": [""]}.
+type Query map[string][]string
+
+// Get returns the first value of key, or "" if there is none.
+func (q Query) Get(key string) string {
+	if values := q[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Has reports whether key appeared in the query, even without a value.
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// ParseTarget parses the request-target of a request with the given
+// method. Each form is only accepted with the methods it is meant for:
+// authority-form with CONNECT and asterisk-form with OPTIONS.
+func ParseTarget(method, raw string) (Target, error) {
+	// Step 1: Reject bytes that can't appear in a request-target.
+	if raw == "" {
+		return Target{}, fmt.Errorf("%w: empty", ErrInvalidTarget)
+	}
+	for i := 0; i < len(raw); i++ {
+		if !isTargetChar(raw[i]) {
+			return Target{}, fmt.Errorf("%w: invalid byte %q", ErrInvalidTarget, raw[i])
+		}
+	}
+
+	// Step 2: Work out the form. CONNECT is the only method that may (and
+	// must) use authority-form.
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(raw)
+	case raw == "*":
+		if method != "OPTIONS" {
+			return Target{}, fmt.Errorf("%w: '*' is only allowed with OPTIONS", ErrInvalidTarget)
+		}
+		return Target{Form: AsteriskForm, Query: Query{}}, nil
+	case strings.HasPrefix(raw, "/"):
+		t := Target{Form: OriginForm}
+		return t, t.parsePathAndQuery(raw)
+	default:
+		return parseAbsoluteForm(raw)
+	}
+}
+
+// parseAuthorityForm parses the "host:port" target of a CONNECT request.
+func parseAuthorityForm(raw string) (Target, error) {
+	colon := strings.LastIndexByte(raw, ':')
+	if colon <= 0 || strings.ContainsAny(raw, "/?#@") || !isPort(raw[colon+1:]) {
+		return Target{}, fmt.Errorf("%w: CONNECT needs host:port, got '%s'", ErrInvalidTarget, raw)
+	}
+	return Target{Form: AuthorityForm, Host: raw, Query: Query{}}, nil
+}
+
+// parseAbsoluteForm parses a target like "http://host:port/path?query".
+func parseAbsoluteForm(raw string) (Target, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || !isScheme(scheme) {
+		return Target{}, fmt.Errorf("%w: '%s' is neither a path nor an absolute URI", ErrInvalidTarget, raw)
+	}
+	scheme = strings.ToLower(scheme)
+	if scheme != "http" && scheme != "https" {
+		return Target{}, fmt.Errorf("%w: unsupported scheme '%s'", ErrInvalidTarget, scheme)
+	}
+
+	// The authority runs up to the path, query or fragment.
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if host == "" {
+		return Target{}, fmt.Errorf("%w: missing host in '%s'", ErrInvalidTarget, raw)
+	}
+	// http(s) URIs must not carry credentials (RFC 9110, Section 4.2.4).
+	if strings.Contains(host, "@") {
+		return Target{}, fmt.Errorf("%w: userinfo is not allowed in '%s'", ErrInvalidTarget, raw)
+	}
+
+	t := Target{Form: AbsoluteForm, Scheme: scheme, Host: host}
+	rest = rest[end:]
+	if !strings.HasPrefix(rest, "/") {
+		// "http://host" and "http://host?q" mean the root path.
+		rest = "/" + rest
+	}
+	return t, t.parsePathAndQuery(rest)
+}
+
+// parsePathAndQuery fills in the path, query and fragment from the part of
+// the target that starts with the path.
+func (t *Target) parsePathAndQuery(s string) error {
+	s, fragment, hasFragment := strings.Cut(s, "#")
+	rawPath, rawQuery, _ := strings.Cut(s, "?")
+
+	path, err := unescape(rawPath, false)
+	if err != nil {
+		return err
+	}
+	query, err := ParseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	if hasFragment {
+		if t.Fragment, err = unescape(fragment, false); err != nil {
+			return err
+		}
+	}
+	t.RawPath = rawPath
+	t.Path = removeDotSegments(path)
+	t.RawQuery = rawQuery
+	t.Query = query
+	return nil
+}
+
+// ParseQuery decodes a query string such as "a=1&b=two+words". "+" is read
+// as a space, as HTML forms encode it.
+func ParseQuery(raw string) (Query, error) {
+	query := Query{}
+	for raw != "" {
+		var pair string
+		pair, raw, _ = strings.Cut(raw, "&")
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(rawValue, true)
+		if err != nil {
+			return nil, err
+		}
+		query[key] = append(query[key], value)
+	}
+	return query, nil
+}
+
+// unescape decodes %XX escapes, and "+" as a space when plusIsSpace is
+// set. An escape that isn't two hex digits, or one that decodes to a
+// control byte, is an error: smuggling a NUL or CRLF into a path is never
+// legitimate.
+func unescape(s string, plusIsSpace bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+				return "", fmt.Errorf("%w: bad escape in '%s'", ErrInvalidTarget, s)
+			}
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if decoded < 0x20 || decoded == 0x7f {
+				return "", fmt.Errorf("%w: escaped control character in '%s'", ErrInvalidTarget, s)
+			}
+			b.WriteByte(decoded)
+			i += 2
+		case c == '+' && plusIsSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// removeDotSegments resolves "." and ".." in a path (RFC 3986, Section
+// 5.2.4), so "/a/./b/../c" becomes "/a/c". ".." never climbs above the
+// root, which keeps "/../../etc/passwd" inside the site.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+// isTargetChar reports whether c may appear in a request-target: the
+// characters RFC 3986 allows in a URI, plus "#" so that a stray fragment
+// can be parsed rather than rejected.
+func isTargetChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/?%#[]", c) >= 0
+}
+
+// isScheme reports whether s is a valid URI scheme: a letter followed by
+// letters, digits, "+", "-" or ".".
+func isScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if i == 0 && !letter {
+			return false
+		}
+		if !letter && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// isPort reports whether s is a non-empty run of digits.
+func isPort(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"HttpFromTcp/internal/headers"
+)
+
+// ErrChunkedWriterClosed is returned when writing to a ChunkedWriter whose
+// terminating chunk has already been sent.
+var ErrChunkedWriterClosed = errors.New("chunked writer is closed")
+
+// ChunkedWriter writes a message body using Transfer-Encoding: chunked.
+// Every Write becomes one chunk on the wire:
+//
+//	<size in hex>\r\n
+//	<data>\r\n
+//
+// and Close sends the zero-length chunk that ends the body.
+type ChunkedWriter struct {
+	w      io.Writer
+	closed bool
+}
+
+// NewChunkedWriter returns a ChunkedWriter that writes chunks to w.
+func NewChunkedWriter(w io.Writer) *ChunkedWriter {
+	return &ChunkedWriter{w: w}
+}
+
+// Write sends p as a single chunk.
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, ErrChunkedWriterClosed
+	}
+	// A zero-length chunk would tell the client the body is over.
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := cw.w.Write([]byte("\r\n")); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// Close sends the terminating zero-length chunk with no trailers.
+func (cw *ChunkedWriter) Close() error {
+	return cw.CloseWithTrailers(nil)
+}
+
+// CloseWithTrailers sends the terminating zero-length chunk followed by the
+// given trailer fields and the final blank line. If the trailers can't be
+// sent, the body is still ended properly, without them, and the error is
+// returned.
+func (cw *ChunkedWriter) CloseWithTrailers(trailers *headers.Headers) error {
+	if cw.closed {
+		return ErrChunkedWriterClosed
+	}
+	cw.closed = true
+	// Checked before anything goes out: a trailer section that fails
+	// halfway would leave the client waiting for the end of the body.
+	if err := ValidateTrailers(trailers); err != nil {
+		if _, writeErr := cw.w.Write([]byte("0\r\n\r\n")); writeErr != nil {
+			return writeErr
+		}
+		return err
+	}
+	if _, err := cw.w.Write([]byte("0\r\n")); err != nil {
+		return err
+	}
+	return WriteTrailers(cw.w, trailers)
+}
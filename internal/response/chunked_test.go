@@ -0,0 +1,70 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"HttpFromTcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedWriter(t *testing.T) {
+	t.Run("Chunks and terminating chunk", func(t *testing.T) {
+		var buf bytes.Buffer
+		cw := NewChunkedWriter(&buf)
+
+		n, err := cw.Write([]byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		_, err = cw.Write([]byte(", this chunk is longer"))
+		require.NoError(t, err)
+		require.NoError(t, cw.Close())
+
+		assert.Equal(t, "5\r\nhello\r\n16\r\n, this chunk is longer\r\n0\r\n\r\n", buf.String())
+	})
+
+	t.Run("Empty write does not end the body", func(t *testing.T) {
+		var buf bytes.Buffer
+		cw := NewChunkedWriter(&buf)
+
+		n, err := cw.Write(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("Trailers follow the last chunk", func(t *testing.T) {
+		var buf bytes.Buffer
+		cw := NewChunkedWriter(&buf)
+		trailers := headers.NewHeaders()
+		trailers.Set("x-checksum", "abc123")
+
+		_, err := cw.Write([]byte("data"))
+		require.NoError(t, err)
+		require.NoError(t, cw.CloseWithTrailers(trailers))
+
+		assert.Equal(t, "4\r\ndata\r\n0\r\nX-Checksum: abc123\r\n\r\n", buf.String())
+	})
+
+	t.Run("Framing fields are not allowed as trailers", func(t *testing.T) {
+		var buf bytes.Buffer
+		cw := NewChunkedWriter(&buf)
+		trailers := headers.NewHeaders()
+		trailers.Set("Content-Length", "4")
+
+		err := cw.CloseWithTrailers(trailers)
+		assert.ErrorIs(t, err, ErrTrailerNotAllowed)
+		assert.Equal(t, "0\r\n\r\n", buf.String(), "the body should still be terminated")
+	})
+
+	t.Run("Write after close", func(t *testing.T) {
+		cw := NewChunkedWriter(&bytes.Buffer{})
+		require.NoError(t, cw.Close())
+
+		_, err := cw.Write([]byte("late"))
+		assert.ErrorIs(t, err, ErrChunkedWriterClosed)
+		assert.ErrorIs(t, cw.Close(), ErrChunkedWriterClosed)
+	})
+}
@@ -2,35 +2,29 @@ package response
 
 import (
 	"HttpFromTcp/internal/headers"
+	"errors"
 	"fmt"
 	"io"
-	"net/textproto"
 	"strconv"
+	"strings"
 )
 
-type StatusCode int
-
-const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-)
-
-// reasonPhrases maps status codes to their standard reason phrases
-
-var reasonPhrases = map[StatusCode]string{
-	StatusOK:                  "OK",
-	StatusBadRequest:          "Bad Request",
-	StatusInternalServerError: "Internal Server Error",
-}
+// ErrInvalidStatusCode is returned by WriteStatusLine for a code that isn't
+// three digits long.
+var ErrInvalidStatusCode = errors.New("invalid status code")
 
 // WriteStatusLin writes the first line of the HTTP response
 //(e.g., "HTTP/1.1 200 OK").
 
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
+	// a status line needs exactly three digits
+	if !statusCode.IsValid() {
+		return fmt.Errorf("%w: %d", ErrInvalidStatusCode, statusCode)
+	}
 	// get the reason phrase for the status code from the map
 	reasonPhrase := reasonPhrases[statusCode]
-	// if the code isnot in the map the phrase will be an empty string
+	// if the code isnot in the map the phrase will be an empty string,
+	// which is still a valid status line ("HTTP/1.1 599 \r\n")
 
 	// format the status line string
 	line := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase)
@@ -40,26 +34,173 @@ func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
 	return err
 }
 
-func GetDefaultHeaders(constentLen int) headers.Headers {
+func GetDefaultHeaders(constentLen int) *headers.Headers {
 	h := headers.NewHeaders()
-	h["Content-Length"] = strconv.Itoa(constentLen)
-	h["Connection"] = "close"
-	h["Content-Type"] = "text/plain"
+	h.Set("Content-Length", strconv.Itoa(constentLen))
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/plain")
 	return h
 }
 
-func WriteHeaders(w io.Writer, h headers.Headers) error {
-	for key, value := range h {
-		// // Canonicalize the key for proper HTTP formatting (e.g., "content-type" -> "Content-Type").
-		canonicalKey := textproto.CanonicalMIMEHeaderKey(key)
-		// format the header line
-		line := fmt.Sprintf("%s: %s\r\n", canonicalKey, value)
-		// write the line
-		if _, err := w.Write([]byte(line)); err != nil {
-			return err
+// leadingFields are written ahead of every other field, in this order, so
+// the fields people (and golden files) look for first are always in the same
+// place. The remaining fields follow in the order they were added.
+var leadingFields = []string{"Date", "Server", "Content-Type"}
+
+// WriteHeaders writes the header block followed by the blank line that ends
+// it. The output is deterministic: leadingFields first, then everything else
+// in insertion order, with repeated fields on their own lines.
+func WriteHeaders(w io.Writer, h *headers.Headers) error {
+	// Step 0: Refuse to write anything if a field would corrupt the
+	// message, so the caller can still send a proper error instead.
+	if err := ValidateHeaders(h); err != nil {
+		return err
+	}
+	var b strings.Builder
+	// Step 1: Write the leading fields that are present, keeping all of
+	// their values.
+	for _, name := range leadingFields {
+		for _, value := range h.Values(name) {
+			writeField(&b, name, value)
 		}
 	}
-	// write the final blank line that separates the headers from the body
-	_, err := w.Write([]byte("\r\n"))
+	// Step 2: Write the rest in insertion order. All yields the keys in
+	// canonical form (e.g., "content-type" -> "Content-Type").
+	for canonicalKey, value := range h.All() {
+		if isLeadingField(canonicalKey) {
+			continue
+		}
+		writeField(&b, canonicalKey, value)
+	}
+	// Step 3: Add the final blank line that separates the headers from the
+	// body, and send the whole block in one write.
+	b.WriteString("\r\n")
+	_, err := io.WriteString(w, b.String())
 	return err
 }
+
+// writeField appends one "Name: value" line.
+func writeField(b *strings.Builder, name, value string) {
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteString("\r\n")
+}
+
+// isLeadingField reports whether the canonical name is one of leadingFields.
+func isLeadingField(name string) bool {
+	for _, leading := range leadingFields {
+		if name == leading {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrInvalidHeaderField is returned by WriteHeaders and ValidateHeaders for a
+// field whose name isn't a token or whose value contains CR, LF or NUL.
+var ErrInvalidHeaderField = errors.New("invalid header field")
+
+// ValidateHeaders checks every field in h the way WriteHeaders does before
+// writing: names must be made of tchars (the rule Parse applies to incoming
+// fields) and values must not contain CR, LF or NUL. Writing such a field
+// verbatim would let a value echoed from user input split the response.
+func ValidateHeaders(h *headers.Headers) error {
+	for name, value := range h.All() {
+		if err := validateField(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateField checks a single field for ValidateHeaders.
+func validateField(name, value string) error {
+	if !headers.ValidFieldName(name) {
+		return fmt.Errorf("%w: field name %q", ErrInvalidHeaderField, name)
+	}
+	if !headers.ValidFieldValue(value) {
+		return fmt.Errorf("%w: value of %s contains CR, LF or NUL: %q", ErrInvalidHeaderField, name, value)
+	}
+	return nil
+}
+
+// ErrNotInformational is returned by WriteInformational for a status code
+// outside the 1xx class.
+var ErrNotInformational = errors.New("not an informational status code")
+
+// WriteInformational writes a complete interim (1xx) response: its status
+// line and header block. Any number of them may come before the final
+// response, e.g. a 103 Early Hints carrying Link headers (RFC 8297). A 1xx
+// response ends with its headers; it never has a body. The headers are
+// checked before anything is written.
+func WriteInformational(w io.Writer, statusCode StatusCode, h *headers.Headers) error {
+	if !statusCode.IsInformational() {
+		return fmt.Errorf("%w: %d", ErrNotInformational, statusCode)
+	}
+	if err := ValidateHeaders(h); err != nil {
+		return err
+	}
+	if err := WriteStatusLine(w, statusCode); err != nil {
+		return err
+	}
+	return WriteHeaders(w, h)
+}
+
+// ErrTrailerNotAllowed is returned by WriteTrailers for fields that must not
+// appear in a trailer section.
+var ErrTrailerNotAllowed = errors.New("field is not allowed in trailers")
+
+// disallowedTrailers lists fields a recipient needs before it can process the
+// body (framing, routing, authentication, content handling), which therefore
+// can't be sent after it (RFC 9110, Section 6.5.1).
+var disallowedTrailers = map[string]bool{
+	"authorization":      true,
+	"cache-control":      true,
+	"content-encoding":   true,
+	"content-length":     true,
+	"content-range":      true,
+	"content-type":       true,
+	"expect":             true,
+	"host":               true,
+	"max-forwards":       true,
+	"proxy-authenticate": true,
+	"range":              true,
+	"set-cookie":         true,
+	"te":                 true,
+	"trailer":            true,
+	"transfer-encoding":  true,
+	"www-authenticate":   true,
+}
+
+// WriteTrailers writes the trailer section that follows the last chunk of a
+// chunked body. It has the same layout as a header block and is likewise
+// terminated by a blank line. Fields that may not be sent as trailers are
+// rejected before anything is written, as are malformed ones.
+func WriteTrailers(w io.Writer, trailers *headers.Headers) error {
+	if err := ValidateTrailers(trailers); err != nil {
+		return err
+	}
+	return WriteHeaders(w, trailers)
+}
+
+// ValidateTrailers checks every field in trailers the way WriteTrailers
+// does before writing.
+func ValidateTrailers(trailers *headers.Headers) error {
+	for name, value := range trailers.All() {
+		if err := ValidateTrailer(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateTrailer checks a single trailer field. It returns
+// ErrTrailerNotAllowed for a field that can't come after the body, and
+// ErrInvalidHeaderField for one that ValidateHeaders would reject.
+func ValidateTrailer(name, value string) error {
+	if disallowedTrailers[strings.ToLower(name)] {
+		return fmt.Errorf("%w: %s", ErrTrailerNotAllowed, name)
+	}
+	return validateField(name, value)
+}
@@ -0,0 +1,195 @@
+package response
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"HttpFromTcp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update rewrites the golden files instead of comparing against them:
+//
+//	go test ./internal/response -run TestWriteHeaders_Golden -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// assertGolden compares got with testdata/<name>.golden byte for byte.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		require.NoError(t, os.WriteFile(path, got, 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run with -update to create the golden file")
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestWriteHeaders_Golden(t *testing.T) {
+	cases := []struct {
+		name   string
+		status StatusCode
+		build  func(h *headers.Headers)
+	}{
+		{
+			name:   "default_headers",
+			status: StatusOK,
+			build: func(h *headers.Headers) {
+				for name, value := range GetDefaultHeaders(15).All() {
+					h.Add(name, value)
+				}
+			},
+		},
+		{
+			name:   "leading_fields_first",
+			status: StatusNotFound,
+			build: func(h *headers.Headers) {
+				h.Add("x-request-id", "abc123")
+				h.Add("content-type", "text/html")
+				h.Add("Content-Length", "9")
+				h.Add("server", "HttpFromTcp")
+				h.Add("date", "Sat, 17 Oct 2026 12:00:00 GMT")
+			},
+		},
+		{
+			name:   "repeated_fields",
+			status: StatusFound,
+			build: func(h *headers.Headers) {
+				h.Add("Location", "/login")
+				h.Add("Set-Cookie", "a=1; Path=/")
+				h.Add("Cache-Control", "no-store")
+				h.Add("Set-Cookie", "b=2; Path=/; HttpOnly")
+				h.Add("Content-Length", "0")
+			},
+		},
+		{
+			name:   "set_keeps_position",
+			status: StatusCreated,
+			build: func(h *headers.Headers) {
+				h.Add("Location", "/things/1")
+				h.Add("Content-Length", "99")
+				h.Add("Connection", "keep-alive")
+				h.Set("content-length", "2")
+			},
+		},
+		{
+			name:   "early_hints",
+			status: StatusEarlyHints,
+			build: func(h *headers.Headers) {
+				h.Add("Link", "</style.css>; rel=preload; as=style")
+				h.Add("Link", "</app.js>; rel=preload; as=script")
+			},
+		},
+		{
+			name:   "no_headers",
+			status: StatusNoContent,
+			build:  func(h *headers.Headers) {},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := headers.NewHeaders()
+			tc.build(h)
+
+			var buf bytes.Buffer
+			require.NoError(t, WriteStatusLine(&buf, tc.status))
+			require.NoError(t, WriteHeaders(&buf, h))
+			assertGolden(t, tc.name, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteHeaders_Deterministic(t *testing.T) {
+	h := headers.NewHeaders()
+	for _, name := range []string{"X-E", "X-D", "Content-Type", "X-C", "X-B", "Date", "X-A"} {
+		h.Add(name, "v")
+	}
+
+	var first bytes.Buffer
+	require.NoError(t, WriteHeaders(&first, h))
+	for i := 0; i < 50; i++ {
+		var again bytes.Buffer
+		require.NoError(t, WriteHeaders(&again, h))
+		require.Equal(t, first.String(), again.String())
+	}
+	assert.Equal(t, "Date: v\r\nContent-Type: v\r\nX-E: v\r\nX-D: v\r\nX-C: v\r\nX-B: v\r\nX-A: v\r\n\r\n", first.String())
+}
+
+func TestWriteHeaders_Validation(t *testing.T) {
+	cases := []struct {
+		name, key, value string
+	}{
+		{"CRLF in value", "X-Echo", "hi\r\nSet-Cookie: a=1"},
+		{"Bare LF in value", "X-Echo", "hi\nthere"},
+		{"Bare CR in value", "X-Echo", "hi\rthere"},
+		{"NUL in value", "X-Echo", "hi\x00there"},
+		{"Space in name", "X Echo", "hi"},
+		{"Colon in name", "X-Echo:", "hi"},
+		{"Empty name", "", "hi"},
+		{"Non-ASCII name", "X-Échо", "hi"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := headers.NewHeaders()
+			h.Add("Content-Length", "0")
+			h.Add(tc.key, tc.value)
+
+			var buf bytes.Buffer
+			err := WriteHeaders(&buf, h)
+			assert.ErrorIs(t, err, ErrInvalidHeaderField)
+			assert.Empty(t, buf.String(), "nothing should be written")
+		})
+	}
+
+	t.Run("Tabs and non-ASCII values are fine", func(t *testing.T) {
+		h := headers.NewHeaders()
+		h.Add("X-Note", "a\tb caf\xc3\xa9")
+		assert.NoError(t, ValidateHeaders(h))
+	})
+
+	t.Run("Invalid trailer is not written", func(t *testing.T) {
+		var buf bytes.Buffer
+		cw := NewChunkedWriter(&buf)
+		trailers := headers.NewHeaders()
+		trailers.Add("X-Checksum", "abc\r\n\r\ninjected")
+
+		assert.ErrorIs(t, cw.CloseWithTrailers(trailers), ErrInvalidHeaderField)
+		assert.Equal(t, "0\r\n\r\n", buf.String(), "the body should still be terminated")
+	})
+}
+
+func TestWriteInformational(t *testing.T) {
+	t.Run("Interim responses before the final one", func(t *testing.T) {
+		hints := headers.NewHeaders()
+		hints.Add("Link", "</style.css>; rel=preload; as=style")
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteInformational(&buf, StatusContinue, nil))
+		require.NoError(t, WriteInformational(&buf, StatusEarlyHints, hints))
+		require.NoError(t, WriteStatusLine(&buf, StatusNoContent))
+		require.NoError(t, WriteHeaders(&buf, hints))
+		assertGolden(t, "interim_then_final", buf.Bytes())
+	})
+
+	t.Run("Final status codes are refused", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := WriteInformational(&buf, StatusOK, nil)
+		assert.ErrorIs(t, err, ErrNotInformational)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("Invalid headers write nothing", func(t *testing.T) {
+		h := headers.NewHeaders()
+		h.Add("Link", "</a>\r\nX-Injected: 1")
+
+		var buf bytes.Buffer
+		err := WriteInformational(&buf, StatusEarlyHints, h)
+		assert.ErrorIs(t, err, ErrInvalidHeaderField)
+		assert.Empty(t, buf.String())
+	})
+}
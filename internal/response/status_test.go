@@ -0,0 +1,75 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusText(t *testing.T) {
+	t.Run("Registered codes have reason phrases", func(t *testing.T) {
+		assert.Equal(t, "Created", StatusText(StatusCreated))
+		assert.Equal(t, "No Content", StatusText(StatusNoContent))
+		assert.Equal(t, "Moved Permanently", StatusText(StatusMovedPermanently))
+		assert.Equal(t, "Method Not Allowed", StatusText(StatusMethodNotAllowed))
+		assert.Equal(t, "Too Many Requests", StatusText(StatusTooManyRequests))
+		assert.Equal(t, "Service Unavailable", StatusText(StatusServiceUnavailable))
+	})
+
+	t.Run("Unknown code has no reason phrase", func(t *testing.T) {
+		assert.Equal(t, "", StatusText(StatusCode(299)))
+	})
+}
+
+func TestStatusCode_Classes(t *testing.T) {
+	cases := []struct {
+		code                                             StatusCode
+		informational, success, redirect, client, server bool
+	}{
+		{StatusEarlyHints, true, false, false, false, false},
+		{StatusOK, false, true, false, false, false},
+		{StatusNotModified, false, false, true, false, false},
+		{StatusNotFound, false, false, false, true, false},
+		{StatusBadGateway, false, false, false, false, true},
+	}
+	for _, tc := range cases {
+		t.Run(StatusText(tc.code), func(t *testing.T) {
+			assert.Equal(t, tc.informational, tc.code.IsInformational())
+			assert.Equal(t, tc.success, tc.code.IsSuccess())
+			assert.Equal(t, tc.redirect, tc.code.IsRedirect())
+			assert.Equal(t, tc.client, tc.code.IsClientError())
+			assert.Equal(t, tc.server, tc.code.IsServerError())
+		})
+	}
+
+	t.Run("Bodiless statuses", func(t *testing.T) {
+		assert.False(t, StatusContinue.AllowsBody())
+		assert.False(t, StatusNoContent.AllowsBody())
+		assert.False(t, StatusNotModified.AllowsBody())
+		assert.True(t, StatusOK.AllowsBody())
+		assert.True(t, StatusNotFound.AllowsBody())
+	})
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	t.Run("Known code", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WriteStatusLine(&buf, StatusTemporaryRedirect))
+		assert.Equal(t, "HTTP/1.1 307 Temporary Redirect\r\n", buf.String())
+	})
+
+	t.Run("Unknown code keeps the space before the empty reason", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WriteStatusLine(&buf, StatusCode(599)))
+		assert.Equal(t, "HTTP/1.1 599 \r\n", buf.String())
+	})
+
+	t.Run("Code that isn't three digits", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := WriteStatusLine(&buf, StatusCode(42))
+		assert.ErrorIs(t, err, ErrInvalidStatusCode)
+		assert.Empty(t, buf.String())
+	})
+}
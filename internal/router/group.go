@@ -0,0 +1,67 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"HttpFromTcp/internal/server"
+)
+
+// Group registers routes that share a path prefix, e.g. every route under
+// "/api/v1".
+type Group struct {
+	router *Router
+	prefix string
+	// middleware wraps every route registered through the group.
+	middleware []server.Middleware
+}
+
+// Group returns a Group whose routes all start with prefix.
+func (r *Router) Group(prefix string) *Group {
+	return &Group{router: r, prefix: cleanPrefix(prefix)}
+}
+
+// Group returns a nested Group whose prefix is appended to g's. It starts
+// with g's middleware; middleware added to it later doesn't affect g.
+func (g *Group) Group(prefix string) *Group {
+	return &Group{
+		router:     g.router,
+		prefix:     g.prefix + cleanPrefix(prefix),
+		middleware: append([]server.Middleware(nil), g.middleware...),
+	}
+}
+
+// Use adds middleware that runs around the routes registered through the
+// group from now on, inside any middleware added to the router.
+func (g *Group) Use(middleware ...server.Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// Handle registers h for method and the group prefix followed by pattern.
+func (g *Group) Handle(method, pattern string, h server.Handler) {
+	g.router.Handle(method, g.prefix+pattern, server.Chain(g.middleware...)(h))
+}
+
+// GET registers h for GET requests matching pattern under the prefix.
+func (g *Group) GET(pattern string, h server.Handler) { g.Handle("GET", pattern, h) }
+
+// POST registers h for POST requests matching pattern under the prefix.
+func (g *Group) POST(pattern string, h server.Handler) { g.Handle("POST", pattern, h) }
+
+// PUT registers h for PUT requests matching pattern under the prefix.
+func (g *Group) PUT(pattern string, h server.Handler) { g.Handle("PUT", pattern, h) }
+
+// PATCH registers h for PATCH requests matching pattern under the prefix.
+func (g *Group) PATCH(pattern string, h server.Handler) { g.Handle("PATCH", pattern, h) }
+
+// DELETE registers h for DELETE requests matching pattern under the prefix.
+func (g *Group) DELETE(pattern string, h server.Handler) { g.Handle("DELETE", pattern, h) }
+
+// cleanPrefix checks a group prefix and drops its trailing slash, so
+// Group("/api/") and Group("/api") are the same.
+func cleanPrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		panic(fmt.Sprintf("router: group prefix %q must start with '/'", prefix))
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
@@ -0,0 +1,302 @@
+// Package router dispatches requests to handlers by method and path.
+//
+// Patterns are made of "/"-separated segments. A segment is either literal
+// text, a ":name" parameter that matches exactly one non-empty segment, or a
+// "*name" wildcard that matches the rest of the path and must come last:
+//
+//	r := router.New()
+//	r.GET("/users/:id", showUser)
+//	r.GET("/static/*file", serveFile)
+//	server.Serve(42069, r.ServeRequest)
+//
+// Routes match the decoded, normalized path in req.Target, so "/a/%62"
+// and "http://host/a/./b" both reach "/a/b". Handlers read matched values
+// with req.PathValue("id"). When several
+// routes could match, literal segments win over parameters and parameters
+// win over wildcards.
+//
+// HEAD and OPTIONS are answered without registering them. A HEAD request
+// runs the route's GET handler (the server sends its headers and drops the
+// body). An OPTIONS request gets a 204 with an Allow header listing the
+// route's methods, and "OPTIONS *" lists every method the router serves.
+// Registering either method for a route replaces the automatic answer.
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/server"
+)
+
+// Router sends each request to the handler registered for its method and
+// path. Its ServeRequest method is a server.Handler.
+type Router struct {
+	root *node
+	// middleware runs around every request, matched or not.
+	middleware []server.Middleware
+
+	// NotFound handles requests whose path matches no route. If nil, a
+	// plain 404 Not Found is sent.
+	NotFound server.Handler
+	// MethodNotAllowed handles requests whose path matches a route that
+	// doesn't accept the method. The Allow header is already set when it
+	// runs. If nil, a plain 405 Method Not Allowed is sent.
+	MethodNotAllowed server.Handler
+}
+
+// New creates an empty Router.
+func New() *Router {
+	return &Router{root: &node{}}
+}
+
+// node is one segment of the route tree.
+type node struct {
+	static   map[string]*node
+	param    *node
+	wildcard *node
+	// name is the parameter name for param and wildcard nodes.
+	name string
+	// handlers maps methods to the handlers of the route ending here.
+	handlers map[string]server.Handler
+}
+
+// pathValue is a parameter matched while walking the tree.
+type pathValue struct {
+	name, value string
+}
+
+// Handle registers h for requests with the given method and path pattern.
+// It panics if the pattern is malformed or the route is already taken,
+// since that is a programming error rather than something to recover from.
+func (r *Router) Handle(method, pattern string, h server.Handler) {
+	if method == "" {
+		panic("router: empty method")
+	}
+	if h == nil {
+		panic("router: nil handler for " + method + " " + pattern)
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("router: pattern %q must start with '/'", pattern))
+	}
+
+	// Step 1: Walk (and grow) the tree one segment at a time.
+	n := r.root
+	segments := strings.Split(pattern[1:], "/")
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			n = n.child(&n.param, seg[1:], pattern)
+		case strings.HasPrefix(seg, "*"):
+			if i != len(segments)-1 {
+				panic(fmt.Sprintf("router: wildcard in %q must be the last segment", pattern))
+			}
+			n = n.child(&n.wildcard, seg[1:], pattern)
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			if n.static[seg] == nil {
+				n.static[seg] = &node{}
+			}
+			n = n.static[seg]
+		}
+	}
+
+	// Step 2: Attach the handler to the last segment.
+	if n.handlers == nil {
+		n.handlers = make(map[string]server.Handler)
+	}
+	if n.handlers[method] != nil {
+		panic(fmt.Sprintf("router: %s %s is already registered", method, pattern))
+	}
+	n.handlers[method] = h
+}
+
+// child returns the parameter or wildcard child stored in *slot, creating
+// it if needed. Two routes can't name the same position differently.
+func (n *node) child(slot **node, name, pattern string) *node {
+	if name == "" {
+		panic(fmt.Sprintf("router: unnamed parameter in %q", pattern))
+	}
+	if *slot == nil {
+		*slot = &node{name: name}
+	}
+	if (*slot).name != name {
+		panic(fmt.Sprintf("router: parameter %q in %q conflicts with existing %q", name, pattern, (*slot).name))
+	}
+	return *slot
+}
+
+// GET registers h for GET requests matching pattern.
+func (r *Router) GET(pattern string, h server.Handler) { r.Handle("GET", pattern, h) }
+
+// POST registers h for POST requests matching pattern.
+func (r *Router) POST(pattern string, h server.Handler) { r.Handle("POST", pattern, h) }
+
+// PUT registers h for PUT requests matching pattern.
+func (r *Router) PUT(pattern string, h server.Handler) { r.Handle("PUT", pattern, h) }
+
+// PATCH registers h for PATCH requests matching pattern.
+func (r *Router) PATCH(pattern string, h server.Handler) { r.Handle("PATCH", pattern, h) }
+
+// DELETE registers h for DELETE requests matching pattern.
+func (r *Router) DELETE(pattern string, h server.Handler) { r.Handle("DELETE", pattern, h) }
+
+// Use adds middleware that runs around every request the router handles,
+// including the ones answered with 404 or 405. Middleware added by earlier
+// calls runs first.
+func (r *Router) Use(middleware ...server.Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// ServeRequest dispatches req to the matching route. It has the signature
+// of a server.Handler, so a Router is passed to the server as r.ServeRequest.
+func (r *Router) ServeRequest(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+	if len(r.middleware) == 0 {
+		return r.dispatch(w, req)
+	}
+	return server.Chain(r.middleware...)(r.dispatch)(w, req)
+}
+
+// dispatch finds the route for req and runs its handler.
+func (r *Router) dispatch(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+	// "OPTIONS *" asks about the server as a whole rather than a route.
+	if req.Target.Form == request.AsteriskForm {
+		methods := map[string]bool{}
+		r.root.collectMethods(methods)
+		return options(w, allowHeader(methods))
+	}
+
+	var values []pathValue
+	n := r.root.match(splitPath(req), &values)
+	if n == nil {
+		if r.NotFound != nil {
+			return r.NotFound(w, req)
+		}
+		return &server.HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    response.StatusText(response.StatusNotFound) + "\n",
+		}
+	}
+
+	method := req.RequestLine.Method
+	h := n.handlers[method]
+	if h == nil && method == "HEAD" {
+		// The server drops the body of a HEAD response, so GET's handler
+		// produces exactly the headers a GET would get.
+		h = n.handlers["GET"]
+	}
+	if h == nil && method == "OPTIONS" {
+		return options(w, n.allow())
+	}
+	if h == nil {
+		w.Header().Set("Allow", n.allow())
+		if r.MethodNotAllowed != nil {
+			return r.MethodNotAllowed(w, req)
+		}
+		// Written through w rather than returned as a HandlerError so the
+		// Allow header goes out with it.
+		w.WriteHeader(response.StatusMethodNotAllowed)
+		fmt.Fprint(w, response.StatusText(response.StatusMethodNotAllowed)+"\n")
+		return nil
+	}
+
+	for _, v := range values {
+		req.SetPathValue(v.name, v.value)
+	}
+	return h(w, req)
+}
+
+// match finds the route for the remaining path segments, trying literal
+// segments first, then parameters, then wildcards. It backs out of a branch
+// that dead-ends so that "/users/new" can sit next to "/users/:id/edit".
+func (n *node) match(segments []string, values *[]pathValue) *node {
+	if len(segments) == 0 {
+		if len(n.handlers) > 0 {
+			return n
+		}
+		return nil
+	}
+
+	seg := segments[0]
+	if child := n.static[seg]; child != nil {
+		if found := child.match(segments[1:], values); found != nil {
+			return found
+		}
+	}
+	if n.param != nil && seg != "" {
+		*values = append(*values, pathValue{n.param.name, seg})
+		if found := n.param.match(segments[1:], values); found != nil {
+			return found
+		}
+		*values = (*values)[:len(*values)-1]
+	}
+	if n.wildcard != nil {
+		*values = append(*values, pathValue{n.wildcard.name, strings.Join(segments, "/")})
+		return n.wildcard
+	}
+	return nil
+}
+
+// allow lists the methods a route accepts, for the Allow header.
+func (n *node) allow() string {
+	methods := map[string]bool{}
+	n.addMethods(methods)
+	return allowHeader(methods)
+}
+
+// addMethods adds the methods of the route ending at n to set, including
+// the ones the router answers itself: HEAD wherever GET is registered, and
+// OPTIONS.
+func (n *node) addMethods(set map[string]bool) {
+	for method := range n.handlers {
+		set[method] = true
+	}
+	if n.handlers["GET"] != nil {
+		set["HEAD"] = true
+	}
+	set["OPTIONS"] = true
+}
+
+// collectMethods adds the methods of every route at or below n to set.
+func (n *node) collectMethods(set map[string]bool) {
+	if len(n.handlers) > 0 {
+		n.addMethods(set)
+	}
+	for _, child := range n.static {
+		child.collectMethods(set)
+	}
+	for _, child := range []*node{n.param, n.wildcard} {
+		if child != nil {
+			child.collectMethods(set)
+		}
+	}
+}
+
+// allowHeader formats a set of methods as an Allow header value, sorted so
+// the header is the same on every response.
+func allowHeader(set map[string]bool) string {
+	methods := make([]string, 0, len(set))
+	for method := range set {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// options answers an OPTIONS request with the methods in allow and no body.
+func options(w server.ResponseWriter, allow string) *server.HandlerError {
+	w.Header().Set("Allow", allow)
+	w.WriteHeader(response.StatusNoContent)
+	return nil
+}
+
+// splitPath returns the segments of the request's decoded path. The
+// query string and an absolute-form scheme and host play no part.
+func splitPath(req *request.Request) []string {
+	return strings.Split(strings.TrimPrefix(req.Target.Path, "/"), "/")
+}
@@ -0,0 +1,289 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"HttpFromTcp/internal/headers"
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+	"HttpFromTcp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a server.ResponseWriter that keeps the response in memory.
+type recorder struct {
+	header  *headers.Headers
+	trailer *headers.Headers
+	status  response.StatusCode
+	body    bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: headers.NewHeaders(), trailer: headers.NewHeaders()}
+}
+
+func (r *recorder) Header() *headers.Headers  { return r.header }
+func (r *recorder) Trailer() *headers.Headers { return r.trailer }
+
+func (r *recorder) WriteHeader(statusCode response.StatusCode) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(response.StatusOK)
+	return r.body.Write(p)
+}
+
+// serve runs one request through the router and returns what was written,
+// along with the handler error if there was one.
+func serve(t *testing.T, r *Router, method, target string) (*recorder, *server.HandlerError) {
+	t.Helper()
+	parsed, err := request.ParseTarget(method, target)
+	require.NoError(t, err)
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: target,
+			HttpVersion:   "1.1",
+		},
+		Target: parsed,
+	}
+	rec := newRecorder()
+	return rec, r.ServeRequest(rec, req)
+}
+
+// say returns a handler that writes text followed by the given parameters.
+func say(text string, params ...string) server.Handler {
+	return func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+		fmt.Fprint(w, text)
+		for _, p := range params {
+			fmt.Fprintf(w, " %s=%s", p, req.PathValue(p))
+		}
+		return nil
+	}
+}
+
+func TestRouter_Matching(t *testing.T) {
+	r := New()
+	r.GET("/", say("home"))
+	r.GET("/users", say("list"))
+	r.POST("/users", say("create"))
+	r.GET("/users/new", say("form"))
+	r.GET("/users/:id", say("show", "id"))
+	r.GET("/users/:id/posts/:post", say("post", "id", "post"))
+	r.GET("/static/*file", say("file", "file"))
+
+	cases := []struct {
+		name, method, target, body string
+	}{
+		{"Root", "GET", "/", "home"},
+		{"Static route", "GET", "/users", "list"},
+		{"Method picks the handler", "POST", "/users", "create"},
+		{"Literal beats parameter", "GET", "/users/new", "form"},
+		{"Parameter", "GET", "/users/42", "show id=42"},
+		{"Several parameters", "GET", "/users/42/posts/7", "post id=42 post=7"},
+		{"Wildcard takes the rest", "GET", "/static/css/site.css", "file file=css/site.css"},
+		{"Query string is ignored", "GET", "/users/42?tab=posts", "show id=42"},
+		{"Absolute-form target", "GET", "http://example.com/users/42", "show id=42"},
+		{"Path is decoded", "GET", "/users/%34%32", "show id=42"},
+		{"Dot segments are resolved", "GET", "/static/../users/./42", "show id=42"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, herr := serve(t, r, tc.method, tc.target)
+			require.Nil(t, herr)
+			assert.Equal(t, tc.body, rec.body.String())
+		})
+	}
+
+	t.Run("Dead end backs out to a parameter", func(t *testing.T) {
+		r := New()
+		r.GET("/users/new/form", say("form"))
+		r.GET("/users/:id", say("show", "id"))
+
+		rec, herr := serve(t, r, "GET", "/users/new")
+		require.Nil(t, herr)
+		assert.Equal(t, "show id=new", rec.body.String())
+	})
+}
+
+func TestRouter_Errors(t *testing.T) {
+	r := New()
+	r.GET("/users/:id", say("show"))
+	r.DELETE("/users/:id", say("delete"))
+
+	t.Run("Unknown path is 404", func(t *testing.T) {
+		_, herr := serve(t, r, "GET", "/nope")
+		require.NotNil(t, herr)
+		assert.Equal(t, response.StatusNotFound, herr.StatusCode)
+	})
+
+	t.Run("Parameter doesn't match an empty segment", func(t *testing.T) {
+		_, herr := serve(t, r, "GET", "/users/")
+		require.NotNil(t, herr)
+		assert.Equal(t, response.StatusNotFound, herr.StatusCode)
+	})
+
+	t.Run("Wrong method is 405 with Allow", func(t *testing.T) {
+		rec, herr := serve(t, r, "PUT", "/users/1")
+		require.Nil(t, herr)
+		assert.Equal(t, response.StatusMethodNotAllowed, rec.status)
+		assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", rec.header.Get("Allow"))
+	})
+
+	t.Run("Custom handlers", func(t *testing.T) {
+		r := New()
+		r.GET("/", say("home"))
+		r.NotFound = say("lost")
+		r.MethodNotAllowed = func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+			return &server.HandlerError{StatusCode: response.StatusMethodNotAllowed, Message: "only " + w.Header().Get("Allow")}
+		}
+
+		rec, herr := serve(t, r, "GET", "/elsewhere")
+		require.Nil(t, herr)
+		assert.Equal(t, "lost", rec.body.String())
+
+		_, herr = serve(t, r, "POST", "/")
+		require.NotNil(t, herr)
+		assert.Equal(t, "only GET, HEAD, OPTIONS", herr.Message)
+	})
+}
+
+func TestRouter_HeadAndOptions(t *testing.T) {
+	r := New()
+	r.GET("/users/:id", say("show", "id"))
+	r.DELETE("/users/:id", say("delete"))
+	r.POST("/users", say("create"))
+	r.PUT("/files/*path", say("upload"))
+
+	t.Run("HEAD runs the GET handler", func(t *testing.T) {
+		rec, herr := serve(t, r, "HEAD", "/users/7")
+		require.Nil(t, herr)
+		// The handler writes as for GET; dropping the body is the server's job.
+		assert.Equal(t, "show id=7", rec.body.String())
+	})
+
+	t.Run("HEAD without a GET route is 405", func(t *testing.T) {
+		rec, herr := serve(t, r, "HEAD", "/users")
+		require.Nil(t, herr)
+		assert.Equal(t, response.StatusMethodNotAllowed, rec.status)
+		assert.Equal(t, "OPTIONS, POST", rec.header.Get("Allow"))
+	})
+
+	t.Run("Registered HEAD handler wins", func(t *testing.T) {
+		r := New()
+		r.GET("/", say("get"))
+		r.Handle("HEAD", "/", say("head"))
+		rec, herr := serve(t, r, "HEAD", "/")
+		require.Nil(t, herr)
+		assert.Equal(t, "head", rec.body.String())
+	})
+
+	t.Run("OPTIONS lists the route's methods", func(t *testing.T) {
+		rec, herr := serve(t, r, "OPTIONS", "/users/7")
+		require.Nil(t, herr)
+		assert.Equal(t, response.StatusNoContent, rec.status)
+		assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", rec.header.Get("Allow"))
+		assert.Empty(t, rec.body.String())
+	})
+
+	t.Run("OPTIONS on an unknown path is 404", func(t *testing.T) {
+		_, herr := serve(t, r, "OPTIONS", "/nope")
+		require.NotNil(t, herr)
+		assert.Equal(t, response.StatusNotFound, herr.StatusCode)
+	})
+
+	t.Run("OPTIONS * lists every method", func(t *testing.T) {
+		rec, herr := serve(t, r, "OPTIONS", "*")
+		require.Nil(t, herr)
+		assert.Equal(t, response.StatusNoContent, rec.status)
+		assert.Equal(t, "DELETE, GET, HEAD, OPTIONS, POST, PUT", rec.header.Get("Allow"))
+	})
+
+	t.Run("Registered OPTIONS handler wins", func(t *testing.T) {
+		r := New()
+		r.Handle("OPTIONS", "/cors", say("preflight"))
+		rec, herr := serve(t, r, "OPTIONS", "/cors")
+		require.Nil(t, herr)
+		assert.Equal(t, "preflight", rec.body.String())
+	})
+}
+
+func TestRouter_Groups(t *testing.T) {
+	r := New()
+	api := r.Group("/api/")
+	api.GET("/status", say("ok"))
+	v1 := api.Group("/v1")
+	v1.GET("/items/:id", say("item", "id"))
+
+	rec, herr := serve(t, r, "GET", "/api/status")
+	require.Nil(t, herr)
+	assert.Equal(t, "ok", rec.body.String())
+
+	rec, herr = serve(t, r, "GET", "/api/v1/items/9")
+	require.Nil(t, herr)
+	assert.Equal(t, "item id=9", rec.body.String())
+}
+
+func TestRouter_BadPatterns(t *testing.T) {
+	t.Run("Relative pattern", func(t *testing.T) {
+		assert.Panics(t, func() { New().GET("users", say("x")) })
+	})
+	t.Run("Wildcard not last", func(t *testing.T) {
+		assert.Panics(t, func() { New().GET("/files/*path/edit", say("x")) })
+	})
+	t.Run("Unnamed parameter", func(t *testing.T) {
+		assert.Panics(t, func() { New().GET("/users/:", say("x")) })
+	})
+	t.Run("Conflicting parameter names", func(t *testing.T) {
+		r := New()
+		r.GET("/users/:id", say("x"))
+		assert.Panics(t, func() { r.GET("/users/:name/posts", say("x")) })
+	})
+	t.Run("Duplicate route", func(t *testing.T) {
+		r := New()
+		r.GET("/", say("x"))
+		assert.Panics(t, func() { r.GET("/", say("y")) })
+	})
+}
+
+func TestRouter_Middleware(t *testing.T) {
+	// tag returns middleware that appends its name to the response body.
+	tag := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w server.ResponseWriter, req *request.Request) *server.HandlerError {
+				fmt.Fprint(w, name+" ")
+				return next(w, req)
+			}
+		}
+	}
+
+	r := New()
+	r.Use(tag("router"))
+	r.GET("/", say("home"))
+	api := r.Group("/api")
+	api.Use(tag("api"))
+	api.GET("/status", say("ok"))
+	admin := api.Group("/admin")
+	admin.Use(tag("admin"))
+	admin.GET("/stats", say("stats"))
+
+	cases := []struct{ target, body string }{
+		{"/", "router home"},
+		{"/api/status", "router api ok"},
+		{"/api/admin/stats", "router api admin stats"},
+		{"/nowhere", "router "},
+	}
+	for _, tc := range cases {
+		t.Run(tc.target, func(t *testing.T) {
+			rec, _ := serve(t, r, "GET", tc.target)
+			assert.Equal(t, tc.body, rec.body.String())
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// stateIdle is a connection waiting for its next request.
+	stateIdle int32 = iota
+	// stateActive is a connection with a request in progress.
+	stateActive
+)
+
+// trackedConn is a client connection plus the state the server keeps for it,
+// so that Shutdown can tell idle keep-alive connections from busy ones.
+type trackedConn struct {
+	net.Conn
+	state atomic.Int32
+
+	// headerTimeout bounds how long the request line and headers may take
+	// once the first byte of a request has arrived.
+	headerTimeout time.Duration
+	// requestStart is when the first byte of the current request arrived.
+	requestStart time.Time
+}
+
+// Read marks the connection active as soon as bytes of a request arrive, so
+// a request that is still being received isn't mistaken for an idle socket.
+// That is also the moment the clock starts on reading the request headers.
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.state.Swap(stateActive) == stateIdle {
+		c.startRequest()
+	}
+	return n, err
+}
+
+// startRequest records the start of a request and arms the header deadline.
+func (c *trackedConn) startRequest() {
+	c.requestStart = time.Now()
+	c.Conn.SetReadDeadline(deadline(c.requestStart, c.headerTimeout))
+}
+
+// deadline returns start+timeout, or the zero time (no deadline) if timeout
+// isn't set.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// trackConn registers conn with the server for the lifetime of handle. If
+// the server is already closed, conn is closed instead and trackConn returns
+// nil. isClosed is set before Close and Shutdown take mu to close the
+// tracked connections, so a connection is either closed by them or here.
+func (s *Server) trackConn(conn net.Conn) *trackedConn {
+	c := &trackedConn{Conn: conn, headerTimeout: s.config.headerTimeout()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isClosed.Load() {
+		conn.Close()
+		return nil
+	}
+	if s.conns == nil {
+		s.conns = make(map[*trackedConn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return c
+}
+
+func (s *Server) untrackConn(c *trackedConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// closeConns closes the tracked connections, or only the idle ones, and
+// reports how many connections are still open afterwards.
+func (s *Server) closeConns(idleOnly bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if idleOnly && c.state.Load() != stateIdle {
+			continue
+		}
+		c.Conn.Close()
+		delete(s.conns, c)
+	}
+	return len(s.conns)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"log"
+
+	"HttpFromTcp/internal/headers"
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+)
+
+// wantsContinue reports whether the client is waiting for "100 Continue"
+// before sending its body. HTTP/1.0 has no 1xx responses, so a 1.0 client
+// never gets one (RFC 9110, Section 15.2) and sends its body regardless.
+func wantsContinue(req *request.Request) bool {
+	return req.RequestLine.HttpVersion == "1.1" && req.ExpectsContinue()
+}
+
+// sendContinue tells a client waiting on "Expect: 100-continue" to go ahead
+// with its body. It does nothing if the client isn't waiting, if it was
+// already told, or if the final response is on the wire: the client has
+// its answer then and won't be sending the body.
+func (w *responseWriter) sendContinue() error {
+	if !w.expectContinue || w.continueSent || w.headerSent {
+		return nil
+	}
+	w.continueSent = true
+	return w.writeInterim(response.StatusContinue, nil)
+}
+
+// bodyWithheld reports whether the client is still holding back a body it
+// asked permission to send. Such a connection can't be reused: the client
+// may send the body later anyway, or never, so there is no telling where
+// the next request starts (RFC 9110, Section 10.1.1).
+func (w *responseWriter) bodyWithheld() bool {
+	return w.expectContinue && !w.continueSent
+}
+
+// writeInterim sends an informational (1xx) response with the given
+// headers ahead of the final one. The final response still has to follow.
+func (w *responseWriter) writeInterim(statusCode response.StatusCode, h *headers.Headers) error {
+	if w.http10 {
+		return nil
+	}
+	return response.WriteInformational(w.conn, statusCode, h)
+}
+
+// interimHeaders returns the headers the handler has set so far, for an
+// informational response. Fields that frame a body or manage the
+// connection only mean something on the final response and are left out.
+func (w *responseWriter) interimHeaders() *headers.Headers {
+	h := w.header.Clone()
+	for _, name := range []string{"Content-Length", "Transfer-Encoding", "Trailer", "Connection"} {
+		h.Del(name)
+	}
+	return h
+}
+
+// writeInformational handles a 1xx status passed to WriteHeader. Any number
+// of them may be sent until the handler picks the final status; after that
+// they are ignored, so exactly one final response ends the exchange.
+//
+// 100 is only sent to a client that asked for it, and without headers.
+// Other codes, such as 103 Early Hints, carry the headers set so far. 101
+// would need the connection handed over to another protocol, which this
+// server doesn't support.
+func (w *responseWriter) writeInformational(statusCode response.StatusCode) {
+	if w.wroteHeader {
+		log.Printf("Informational status %d after the final status %d, ignoring it", statusCode, w.status)
+		return
+	}
+	var err error
+	switch statusCode {
+	case response.StatusContinue:
+		err = w.sendContinue()
+	case response.StatusSwitchingProtocols:
+		log.Printf("Ignoring status 101: switching protocols is not supported")
+	default:
+		err = w.writeInterim(statusCode, w.interimHeaders())
+	}
+	if err != nil {
+		log.Printf("Error writing informational response %d: %v", statusCode, err)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+)
+
+// DefaultMaxJSONBytes is the body size DecodeJSON accepts when no limit is
+// given.
+const DefaultMaxJSONBytes = 1 << 20
+
+// Problem is an RFC 9457 problem details object, sent as an
+// application/problem+json error body.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. Empty means
+	// "about:blank": the problem is just the HTTP status.
+	Type string `json:"type,omitempty"`
+	// Title is a short summary of the kind of problem. For "about:blank"
+	// problems it defaults to the status's reason phrase.
+	Title string `json:"title,omitempty"`
+	// Status is the HTTP status code. It defaults to the HandlerError's.
+	Status response.StatusCode `json:"status,omitempty"`
+	// Detail explains this occurrence of the problem. It defaults to the
+	// HandlerError's Message.
+	Detail string `json:"detail,omitempty"`
+	// Instance is a URI identifying this occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+	// Extensions holds additional members, e.g. a list of invalid fields.
+	// They are sent alongside the standard members.
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON writes the standard members together with the extensions.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type standard Problem // drops this method, avoiding recursion
+	members := make(map[string]any, len(p.Extensions)+5)
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	data, err := json.Marshal((*standard)(p))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// problemDetails fills in the Problem for an error response from the status and
+// message of e.
+func (e *HandlerError) problemDetails() *Problem {
+	p := Problem{}
+	if e.Problem != nil {
+		p = *e.Problem
+	}
+	if p.Status == 0 {
+		p.Status = e.StatusCode
+	}
+	if p.Title == "" && (p.Type == "" || p.Type == "about:blank") {
+		p.Title = response.StatusText(p.Status)
+	}
+	if p.Detail == "" {
+		p.Detail = strings.TrimSpace(e.Message)
+	}
+	return &p
+}
+
+// body returns the Content-Type and body of the error response for e.
+// Errors carrying a Problem, or every error when problemJSON is set, get a
+// problem+json body; the rest get Message as plain text.
+func (e *HandlerError) body(problemJSON bool) (string, []byte) {
+	if e.Problem == nil && !problemJSON {
+		return "text/plain", []byte(e.Message)
+	}
+	data, err := json.Marshal(e.problemDetails())
+	if err != nil {
+		// Only unmarshalable extensions get here; keep the error response.
+		return "text/plain", []byte(e.Message)
+	}
+	return "application/problem+json", append(data, '\n')
+}
+
+// WriteJSON sends v as a JSON response with the given status. v is encoded
+// before anything is written, so an encoding error leaves the response
+// untouched and the handler can still return a HandlerError.
+func WriteJSON(w ResponseWriter, status response.StatusCode, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding JSON response: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// DecodeJSON decodes the JSON body of req into v. It is strict, so that
+// client mistakes surface instead of being silently ignored: the
+// Content-Type must be JSON, fields v doesn't have are rejected, and the
+// body must hold exactly one JSON value of at most maxBytes bytes (zero or
+// less means DefaultMaxJSONBytes).
+//
+// The error is ready to be returned from a handler: 415 for the wrong
+// Content-Type, 413 for a body that is too large, 400 for anything else.
+func DecodeJSON(req *request.Request, v any, maxBytes int64) *HandlerError {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxJSONBytes
+	}
+
+	// Step 1: Check that the client says it is sending JSON.
+	mediaType, _, err := mime.ParseMediaType(req.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return &HandlerError{
+			StatusCode: response.StatusUnsupportedMediaType,
+			Message:    "Content-Type must be application/json\n",
+		}
+	}
+
+	// Step 2: Decode a single value, refusing unknown fields.
+	limited := &io.LimitedReader{R: req.BodyReader(), N: maxBytes + 1}
+	dec := json.NewDecoder(limited)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("body must contain a single JSON value")
+	}
+	if limited.N <= 0 {
+		return &HandlerError{
+			StatusCode: response.StatusContentTooLarge,
+			Message:    fmt.Sprintf("JSON body is larger than %d bytes\n", maxBytes),
+		}
+	}
+	if err != nil {
+		return &HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    "invalid JSON body: " + jsonErrorDetail(err) + "\n",
+		}
+	}
+	return nil
+}
+
+// jsonErrorDetail turns a decoding error into a message fit for clients,
+// leaving out Go type names where the standard errors mention them.
+func jsonErrorDetail(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "body ends in the middle of a value"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("syntax error at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %q has the wrong type (got %s)", typeErr.Field, typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("body has the wrong type (got %s)", typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	return err.Error()
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type coffeeOrder struct {
+	Flavor string `json:"flavor"`
+	Shots  int    `json:"shots"`
+}
+
+// jsonRequest parses a POST request with the given Content-Type and body.
+func jsonRequest(t *testing.T, contentType, body string) *request.Request {
+	t.Helper()
+	raw := fmt.Sprintf("POST /coffee HTTP/1.1\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func TestDecodeJSON(t *testing.T) {
+	t.Run("Valid body", func(t *testing.T) {
+		var order coffeeOrder
+		herr := DecodeJSON(jsonRequest(t, "application/json; charset=utf-8", `{"flavor":"dark mode","shots":2}`), &order, 0)
+		require.Nil(t, herr)
+		assert.Equal(t, coffeeOrder{Flavor: "dark mode", Shots: 2}, order)
+	})
+
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int64
+		status      response.StatusCode
+		message     string
+	}{
+		{"Wrong Content-Type", "text/plain", `{}`, 0, response.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"Unknown field", "application/json", `{"flavor":"x","sugar":true}`, 0, response.StatusBadRequest, `unknown field "sugar"`},
+		{"Wrong type", "application/json", `{"shots":"two"}`, 0, response.StatusBadRequest, `field "shots" has the wrong type (got string)`},
+		{"Syntax error", "application/json", `{"flavor":}`, 0, response.StatusBadRequest, "syntax error at byte 11"},
+		{"Truncated", "application/json", `{"flavor":"x"`, 0, response.StatusBadRequest, "body ends in the middle of a value"},
+		{"Empty", "application/json", ``, 0, response.StatusBadRequest, "body is empty"},
+		{"Two values", "application/json", `{} {}`, 0, response.StatusBadRequest, "single JSON value"},
+		{"Too large", "application/json", `{"flavor":"` + strings.Repeat("a", 100) + `"}`, 64, response.StatusContentTooLarge, "larger than 64 bytes"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var order coffeeOrder
+			herr := DecodeJSON(jsonRequest(t, tc.contentType, tc.body), &order, tc.maxBytes)
+			require.NotNil(t, herr)
+			assert.Equal(t, tc.status, herr.StatusCode)
+			assert.Contains(t, herr.Message, tc.message)
+		})
+	}
+
+	t.Run("Problem+json is accepted", func(t *testing.T) {
+		var order coffeeOrder
+		assert.Nil(t, DecodeJSON(jsonRequest(t, "application/merge-patch+json", `{"shots":1}`), &order, 0))
+	})
+}
+
+func TestServer_JSONResponses(t *testing.T) {
+	t.Run("WriteJSON", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			require.NoError(t, WriteJSON(w, response.StatusCreated, coffeeOrder{Flavor: "mocha", Shots: 1}))
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 201 Created", resp.StatusLine)
+		assert.Equal(t, "application/json", resp.Headers["content-type"])
+		assert.Equal(t, "{\"flavor\":\"mocha\",\"shots\":1}\n", resp.Body)
+	})
+
+	t.Run("WriteJSON leaves the response alone on encoding errors", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			err := WriteJSON(w, response.StatusOK, map[string]any{"bad": make(chan int)})
+			require.Error(t, err)
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+		})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+	})
+
+	t.Run("Problem on a HandlerError", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			return &HandlerError{
+				StatusCode: response.StatusForbidden,
+				Message:    "Your account has no credit.\n",
+				Problem: &Problem{
+					Type:       "https://example.com/probs/out-of-credit",
+					Title:      "You do not have enough credit.",
+					Instance:   "/account/12345/msgs/abc",
+					Extensions: map[string]any{"balance": 30},
+				},
+			}
+		})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 403 Forbidden", resp.StatusLine)
+		assert.Equal(t, "application/problem+json", resp.Headers["content-type"])
+		var body map[string]any
+		require.NoError(t, json.Unmarshal([]byte(resp.Body), &body))
+		assert.Equal(t, map[string]any{
+			"type":     "https://example.com/probs/out-of-credit",
+			"title":    "You do not have enough credit.",
+			"status":   float64(403),
+			"detail":   "Your account has no credit.",
+			"instance": "/account/12345/msgs/abc",
+			"balance":  float64(30),
+		}, body)
+	})
+
+	t.Run("ProblemJSON config covers plain errors and parse errors", func(t *testing.T) {
+		config := Config{ProblemJSON: true}
+		client, _ := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			return &HandlerError{StatusCode: response.StatusNotFound, Message: "no such order\n"}
+		}, config)
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET /orders/9 HTTP/1.1\r\n\r\nGET / HTTP/9.9\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "application/problem+json", resp.Headers["content-type"])
+		assert.JSONEq(t, `{"title":"Not Found","status":404,"detail":"no such order"}`, resp.Body)
+
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 505 HTTP Version Not Supported", resp.StatusLine)
+		assert.JSONEq(t, `{"title":"HTTP Version Not Supported","status":505,"detail":"HTTP Version Not Supported"}`, resp.Body)
+	})
+}
@@ -0,0 +1,37 @@
+package server
+
+// Middleware wraps a Handler with behavior that applies to many handlers,
+// such as logging or authentication. It returns a Handler that usually
+// does some work and then calls next.
+type Middleware func(next Handler) Handler
+
+// Chain combines middleware into one. The first middleware is the
+// outermost: Chain(a, b)(h) runs a, then b, then h.
+func Chain(middleware ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+// Use adds middleware around the server's handler. Middleware added by
+// earlier calls runs first. Requests already being handled keep the chain
+// they started with.
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+	s.wrapped = Chain(s.middleware...)(s.handler)
+}
+
+// currentHandler returns the handler with the middleware added by Use.
+func (s *Server) currentHandler() Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wrapped != nil {
+		return s.wrapped
+	}
+	return s.handler
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+)
+
+// Panic describes a panic the server recovered from. It is passed to
+// Config.PanicHandler so applications can send it to their error tracker.
+type Panic struct {
+	// Value is what was passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+	// Request is the request being served, or nil if the panic happened
+	// outside a handler (e.g. while reading the request).
+	Request *request.Request
+	// RemoteAddr is the address of the client.
+	RemoteAddr string
+}
+
+// String formats the panic the way the server logs it.
+func (p *Panic) String() string {
+	if p.Request == nil {
+		return fmt.Sprintf("Panic on connection from %s: %v\n%s", p.RemoteAddr, p.Value, p.Stack)
+	}
+	line := p.Request.RequestLine
+	return fmt.Sprintf("Panic serving %s %s HTTP/%s from %s: %v\n%s",
+		line.Method, line.RequestTarget, line.HttpVersion, p.RemoteAddr, p.Value, p.Stack)
+}
+
+// runHandler calls the handler, turning a panic into a 500 error. A handler
+// that panicked may have left the request body half read, so panicked tells
+// the caller to close the connection afterwards.
+func (s *Server) runHandler(w *responseWriter, req *request.Request) (herr *HandlerError, panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.reportPanic(&Panic{Value: v, Stack: debug.Stack(), Request: req, RemoteAddr: w.conn.RemoteAddr().String()})
+			herr = &HandlerError{
+				StatusCode: response.StatusInternalServerError,
+				Message:    response.StatusText(response.StatusInternalServerError) + "\n",
+			}
+			panicked = true
+		}
+	}()
+	return s.currentHandler()(w, req), false
+}
+
+// recoverConn is deferred by handle so that a panic anywhere else in the
+// connection's goroutine only costs that connection, not the process.
+func (s *Server) recoverConn(conn *trackedConn) {
+	if v := recover(); v != nil {
+		s.reportPanic(&Panic{Value: v, Stack: debug.Stack(), RemoteAddr: conn.RemoteAddr().String()})
+	}
+}
+
+// reportPanic logs p and hands it to the application's PanicHandler.
+func (s *Server) reportPanic(p *Panic) {
+	log.Print(p)
+	if s.config.PanicHandler != nil {
+		s.config.PanicHandler(p)
+	}
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"HttpFromTcp/internal/headers"
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+)
+
+// ErrBodyTooLong is returned by ResponseWriter.Write when a handler writes
+// more bytes than the Content-Length it declared.
+var ErrBodyTooLong = errors.New("response body is longer than the declared Content-Length")
+
+// ErrBodyNotAllowed is returned by ResponseWriter.Write when the status
+// code doesn't allow a body, e.g. 204 No Content or 304 Not Modified.
+var ErrBodyNotAllowed = errors.New("response status does not allow a body")
+
+// maxBufferedBody is how much of a body without a declared Content-Length
+// is held in memory before the response switches to chunked encoding.
+const maxBufferedBody = 64 * 1024
+
+// ResponseWriter is what a Handler uses to build its response.
+//
+// A handler sets headers through Header, optionally picks a status with
+// WriteHeader, and writes the body with Write. If the handler sets
+// Content-Length itself the body is streamed straight to the connection.
+// If it sets "Transfer-Encoding: chunked", or writes more than fits in the
+// server's buffer, every Write is sent as a chunk. Otherwise the body is
+// buffered so the server can fill in Content-Length once the handler returns.
+//
+// A HEAD request runs the handler like a GET: it writes its body as usual
+// and the response carries the same headers, Content-Length included, but
+// the body bytes are never sent.
+//
+// Trailer fields set through Trailer are sent after a chunked body. A
+// response with trailers is always chunked for HTTP/1.1 clients; HTTP/1.0
+// clients can't receive trailers and never see them.
+type ResponseWriter interface {
+	// Header returns the headers that will be sent with the response.
+	// Changes made after the headers have been sent have no effect.
+	Header() *headers.Headers
+
+	// Trailer returns the trailer fields sent after the body. Handlers
+	// should announce them with a "Trailer" header before the headers are
+	// sent; if they don't, the server announces whatever is set by then.
+	Trailer() *headers.Headers
+
+	// WriteHeader sets the response status code. Only the first call counts.
+	// Any registered code can be used; 204 and 304 responses are sent
+	// without a body.
+	//
+	// A 1xx code is different: it sends an informational response right
+	// away and doesn't count as the status, so it can be called any number
+	// of times before the final status (and is ignored after it). Except
+	// for 100, the informational response carries the headers set so far,
+	// which is how Early Hints are sent: set Link headers, then call
+	// WriteHeader(response.StatusEarlyHints). Those headers stay set for
+	// the final response; Del them first if they shouldn't be repeated.
+	// WriteHeader(100) answers a client that sent "Expect: 100-continue";
+	// the server also sends it on its own once the handler starts reading
+	// the body.
+	WriteHeader(statusCode response.StatusCode)
+
+	// Write appends to the response body. It calls WriteHeader(StatusOK)
+	// first if the handler hasn't picked a status yet, and returns
+	// ErrBodyNotAllowed if the status can't carry a body.
+	Write(p []byte) (int, error)
+}
+
+// responseWriter is the ResponseWriter handed to handlers by the server.
+type responseWriter struct {
+	srv       *Server
+	conn      net.Conn
+	header    *headers.Headers
+	trailer   *headers.Headers
+	status    response.StatusCode
+	keepAlive bool
+	// http10 is set for HTTP/1.0 clients, which don't understand chunked
+	// encoding and get a body delimited by closing the connection instead.
+	http10 bool
+	// head is set for HEAD requests. out is where body bytes go: the
+	// connection, or io.Discard for HEAD.
+	head bool
+	out  io.Writer
+
+	wroteHeader bool // the handler picked a status
+	headerSent  bool // the status line and headers are on the wire
+
+	// expectContinue is set when the client is waiting for "100 Continue"
+	// before it sends the body; continueSent once it has been told.
+	expectContinue bool
+	continueSent   bool
+
+	// body holds the response body until the headers are sent.
+	body bytes.Buffer
+	// contentLength is the length declared by the handler, or -1.
+	contentLength int64
+	written       int64
+	// chunked is set once the body is being streamed in chunks.
+	chunked *response.ChunkedWriter
+}
+
+func newResponseWriter(srv *Server, conn net.Conn, req *request.Request, keepAlive bool) *responseWriter {
+	w := &responseWriter{
+		srv:            srv,
+		conn:           conn,
+		header:         headers.NewHeaders(),
+		trailer:        headers.NewHeaders(),
+		keepAlive:      keepAlive,
+		http10:         req.RequestLine.HttpVersion == "1.0",
+		head:           req.RequestLine.Method == "HEAD",
+		out:            conn,
+		contentLength:  -1,
+		expectContinue: wantsContinue(req),
+	}
+	if w.head {
+		// Framed exactly like the GET response, minus the bytes.
+		w.out = io.Discard
+	}
+	if w.expectContinue {
+		// The client sends the body once told to, so tell it when the
+		// handler first asks for the body.
+		req.BeforeBodyRead(w.sendContinue)
+	}
+	return w
+}
+
+func (w *responseWriter) Header() *headers.Headers {
+	return w.header
+}
+
+func (w *responseWriter) Trailer() *headers.Headers {
+	return w.trailer
+}
+
+// hasTrailers reports whether the handler announced or set any trailers.
+func (w *responseWriter) hasTrailers() bool {
+	return w.header.Get("Trailer") != "" || w.trailer.Len() > 0
+}
+
+func (w *responseWriter) WriteHeader(statusCode response.StatusCode) {
+	if statusCode.IsInformational() {
+		w.writeInformational(statusCode)
+		return
+	}
+	if w.wroteHeader {
+		log.Printf("Superfluous WriteHeader call with status %d", statusCode)
+		return
+	}
+	if !statusCode.IsValid() {
+		log.Printf("Invalid status code %d, sending 500 instead", statusCode)
+		statusCode = response.StatusInternalServerError
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+
+	// Bodiless responses are sent as-is once the handler returns.
+	if !statusCode.AllowsBody() {
+		return
+	}
+
+	// A handler that asks for chunked encoding wants its writes on the
+	// wire as they happen. Transfer-Encoding wins over Content-Length.
+	if strings.EqualFold(w.header.Get("Transfer-Encoding"), "chunked") {
+		if err := w.startStreaming(); err != nil {
+			log.Printf("Error writing headers: %v", err)
+		}
+		return
+	}
+
+	// A handler that declares its own Content-Length doesn't need us to
+	// buffer anything, so the headers can go out right away.
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			log.Printf("Ignoring invalid Content-Length %q set by handler", cl)
+			w.header.Del("content-length")
+			return
+		}
+		w.contentLength = n
+		if err := w.sendHeader(); err != nil {
+			log.Printf("Error writing headers: %v", err)
+		}
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(response.StatusOK)
+	}
+	if !w.status.AllowsBody() {
+		return 0, ErrBodyNotAllowed
+	}
+	if !w.headerSent {
+		if w.body.Len()+len(p) <= maxBufferedBody {
+			return w.body.Write(p)
+		}
+		// Too big to hold on to: send what we have and stream the rest.
+		if err := w.startStreaming(); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case w.chunked != nil:
+		return w.chunked.Write(p)
+	case w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength:
+		return 0, ErrBodyTooLong
+	}
+	n, err := w.out.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// startStreaming sends the headers for a body of unknown length along with
+// anything buffered so far. HTTP/1.1 clients get a chunked body; HTTP/1.0
+// clients get the raw bytes and the connection is closed to mark the end.
+func (w *responseWriter) startStreaming() error {
+	w.header.Del("content-length")
+	w.contentLength = -1
+	if w.http10 {
+		w.header.Del("transfer-encoding")
+		w.keepAlive = false
+	} else {
+		w.header.Set("Transfer-Encoding", "chunked")
+		w.chunked = response.NewChunkedWriter(w.out)
+		w.dropInvalidTrailers()
+		if w.header.Get("Trailer") == "" && w.trailer.Len() > 0 {
+			w.header.Set("Trailer", trailerNames(w.trailer))
+		}
+	}
+	if err := w.sendHeader(); err != nil {
+		return err
+	}
+	if w.body.Len() == 0 {
+		return nil
+	}
+	buffered := w.body.Bytes()
+	w.body = bytes.Buffer{}
+	if w.chunked != nil {
+		_, err := w.chunked.Write(buffered)
+		return err
+	}
+	_, err := w.out.Write(buffered)
+	return err
+}
+
+// sendHeader writes the status line and headers, filling in the defaults
+// the handler didn't set. If a header is invalid nothing is written and
+// headerSent stays false.
+func (w *responseWriter) sendHeader() error {
+	// Respect a handler that wants to close the connection, and don't
+	// promise to keep it open if the server started shutting down while the
+	// handler ran, or if a body the client was told to hold back never came.
+	if strings.EqualFold(w.header.Get("Connection"), "close") || w.srv.isClosed.Load() || w.bodyWithheld() {
+		w.keepAlive = false
+	}
+	connectionHeaders(w.header, w.keepAlive)
+	if w.header.Get("Content-Type") == "" && w.status.AllowsBody() {
+		w.header.Set("Content-Type", "text/plain")
+	}
+
+	// A field that would corrupt the response is caught before anything
+	// is written, so the server can still answer with a 500.
+	if err := response.ValidateHeaders(w.header); err != nil {
+		return err
+	}
+	w.headerSent = true
+	if err := response.WriteStatusLine(w.conn, w.status); err != nil {
+		return fmt.Errorf("writing status line: %w", err)
+	}
+	return response.WriteHeaders(w.conn, w.header)
+}
+
+// finish completes the response once the handler has returned. It sends
+// the buffered body with its Content-Length if nothing went out yet.
+func (w *responseWriter) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(response.StatusOK)
+	}
+	if !w.status.AllowsBody() {
+		return w.finishBodiless()
+	}
+	// Trailers can only ride on a chunked body. Ones that may not be sent
+	// at all are dropped first, so they don't make the body chunked.
+	w.dropInvalidTrailers()
+	if !w.headerSent && w.hasTrailers() && !w.http10 {
+		if err := w.startStreaming(); err != nil {
+			return err
+		}
+	}
+	if !w.headerSent {
+		w.contentLength = int64(w.body.Len())
+		w.header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		if err := w.sendHeader(); err != nil {
+			return err
+		}
+		if w.body.Len() == 0 {
+			return nil
+		}
+		n, err := w.out.Write(w.body.Bytes())
+		w.written = int64(n)
+		return err
+	}
+	if w.chunked != nil {
+		return w.chunked.CloseWithTrailers(w.trailer)
+	}
+	if w.trailer.Len() > 0 {
+		log.Printf("Dropping trailers: response body is not chunked")
+	}
+	// A short body leaves the client waiting for bytes that will never come,
+	// and the connection can't be reused. A HEAD response has no body, so
+	// its handler may declare the length without writing anything.
+	if w.contentLength >= 0 && w.written != w.contentLength && !w.head {
+		w.keepAlive = false
+		return fmt.Errorf("handler wrote %d bytes, declared Content-Length %d", w.written, w.contentLength)
+	}
+	return nil
+}
+
+// finishBodiless sends the headers of a response that ends with them,
+// such as 204 No Content. There is no body to frame, so Transfer-Encoding
+// and Content-Length are dropped; a 304 keeps the Content-Length the
+// handler set, since it describes the representation the client cached.
+func (w *responseWriter) finishBodiless() error {
+	w.header.Del("transfer-encoding")
+	if w.status != response.StatusNotModified {
+		w.header.Del("content-length")
+	}
+	if w.trailer.Len() > 0 {
+		log.Printf("Dropping trailers: status %d has no body", w.status)
+	}
+	return w.sendHeader()
+}
+
+// dropInvalidTrailers removes the trailer fields that can't be sent, such
+// as Content-Length, so they are never announced in the Trailer header and
+// can't spoil the end of the body.
+func (w *responseWriter) dropInvalidTrailers() {
+	var invalid []string
+	for name, value := range w.trailer.All() {
+		if err := response.ValidateTrailer(name, value); err != nil {
+			log.Printf("Dropping trailer: %v", err)
+			invalid = append(invalid, name)
+		}
+	}
+	for _, name := range invalid {
+		w.trailer.Del(name)
+	}
+}
+
+// trailerNames builds a Trailer header value announcing every field in t.
+func trailerNames(t *headers.Headers) string {
+	names := t.Names()
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -1,20 +1,40 @@
 package server
 
 import (
-	"bytes" // We need a buffer to capture the handler's output
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"HttpFromTcp/internal/headers"
 	"HttpFromTcp/internal/request"
 	"HttpFromTcp/internal/response"
 )
 
-// HandlerError represents an error that includes an HTTP status code.
+// defaultIdleTimeout is how long a keep-alive connection may sit between
+// requests before the server gives up on it, unless Config says otherwise.
+const defaultIdleTimeout = 2 * time.Minute
+
+// maxBodyDrain is how much of a request body the handler didn't read the
+// server is willing to throw away to keep the connection open. Past that it's
+// cheaper to close the connection than to read the rest.
+const maxBodyDrain = 256 * 1024
+
+// HandlerError represents an error that includes an HTTP status code. Any
+// status from the response package can be used, e.g. 404 or 503; Message
+// becomes the plain-text body unless the error is sent as problem+json.
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
+	// Problem, if set, makes the error response an RFC 9457
+	// application/problem+json body. Fields left empty are filled in from
+	// StatusCode and Message. See also Config.ProblemJSON.
+	Problem *Problem
 }
 
 // Error makes HandlerError satisfy the standard error interface.
@@ -23,17 +43,136 @@ func (e *HandlerError) Error() string {
 }
 
 // Handler is a function type that defines our application logic.
-// It takes a writer to build the response body and the parsed request.
-type Handler func(w *bytes.Buffer, req *request.Request) *HandlerError
+// It takes a ResponseWriter to build the response and the parsed request.
+// Returning a HandlerError before anything has been written replaces the
+// response with an error response.
+//
+// Requests reach the handler with their method and target as sent. For a
+// HEAD request the server sends the headers the handler produces and drops
+// the body, but it is up to the handler to treat HEAD like GET, and to
+// answer OPTIONS, including "OPTIONS *". router.Router does both: use its
+// ServeRequest as the handler to get HEAD and OPTIONS answered for every
+// route.
+type Handler func(w ResponseWriter, req *request.Request) *HandlerError
+
+// Config holds the settings a Server runs with. The zero value gives the
+// default behavior.
+type Config struct {
+	// StreamRequestBody hands each request to the handler as soon as its
+	// headers are parsed. The body is left on the connection and read
+	// through req.BodyReader(), so large uploads never sit in memory; Body
+	// stays nil. When false, the whole body is read into req.Body first.
+	// Limits.MaxBodyBytes still applies to a streamed body, and defaults
+	// to 10 MiB; set it to -1 to accept uploads of any size.
+	StreamRequestBody bool
+
+	// EagerContinue answers "Expect: 100-continue" as soon as the request
+	// headers are read, before the handler runs. By default a streamed
+	// body gets its "100 Continue" when the handler first reads it, so a
+	// handler can turn the request down (e.g. with 413 or 417) before the
+	// client sends the body. Without StreamRequestBody the server reads the
+	// body before calling the handler and always answers right away.
+	EagerContinue bool
+
+	// ReadHeaderTimeout is how long a client has to send the request line
+	// and headers, counted from the first byte of the request. Zero means
+	// ReadTimeout is used instead, or IdleTimeout if that is zero too, so
+	// a client can never stall a request forever.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long a client has to send the whole request, body
+	// included, counted from the first byte of the request. With
+	// StreamRequestBody this also bounds the handler's reads. Zero means no
+	// timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long the server has to write the response once
+	// the request headers have been read. Zero means no timeout.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may wait for its
+	// next request. Zero means a default of two minutes.
+	IdleTimeout time.Duration
+
+	// Limits caps the size of the requests the server accepts. Requests
+	// over a limit are answered with 414, 431 or 413. A field left at zero
+	// takes its value from request.DefaultLimits(), so raising one limit
+	// keeps the others; set a field to -1 to remove that limit. The body
+	// limit applies to streamed bodies too, so large uploads with
+	// StreamRequestBody need a higher (or no) MaxBodyBytes.
+	Limits request.Limits
+
+	// PanicHandler, if set, is called with every panic the server recovers
+	// from, after it has been logged. A panicking handler gets a 500
+	// response (or, if it had started its response, a closed connection)
+	// instead of crashing the process. PanicHandler runs on the
+	// connection's goroutine and must not panic itself.
+	PanicHandler func(p *Panic)
+
+	// ProblemJSON sends every error response, including the ones for
+	// requests that couldn't be parsed, as an RFC 9457
+	// application/problem+json body instead of plain text. The Message of
+	// a HandlerError becomes the problem's "detail".
+	ProblemJSON bool
+}
+
+// limits is the effective Limits: the defaults, overridden field by field
+// by whatever Limits sets.
+func (c Config) limits() request.Limits {
+	limits := request.DefaultLimits()
+	override := func(field *int, value int) {
+		if value != 0 {
+			*field = value
+		}
+	}
+	override(&limits.MaxRequestLineBytes, c.Limits.MaxRequestLineBytes)
+	override(&limits.MaxHeaderBytes, c.Limits.MaxHeaderBytes)
+	override(&limits.MaxHeaderCount, c.Limits.MaxHeaderCount)
+	override(&limits.MaxBodyBytes, c.Limits.MaxBodyBytes)
+	return limits
+}
+
+// headerTimeout is the effective ReadHeaderTimeout. It is never zero: a
+// client that sends one byte and then stalls would otherwise hold its
+// connection open for good.
+func (c Config) headerTimeout() time.Duration {
+	if c.ReadHeaderTimeout > 0 {
+		return c.ReadHeaderTimeout
+	}
+	if c.ReadTimeout > 0 {
+		return c.ReadTimeout
+	}
+	return c.idleTimeout()
+}
+
+// idleTimeout is the effective IdleTimeout.
+func (c Config) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
 
 type Server struct {
 	listener net.Listener
 	isClosed atomic.Bool
 	handler  Handler // The server now holds a reference to the handler.
+	config   Config
+
+	// mu guards conns, the set of live client connections, and the
+	// middleware added with Use.
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+	// middleware is applied around handler; wrapped is the result.
+	middleware []Middleware
+	wrapped    Handler
 }
 
-// Serve now accepts a handler function to process requests.
+// Serve now accepts a handler function to process requests. See Handler
+// for what the server does and doesn't do for HEAD and OPTIONS.
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, Config{})
+}
+
+// ServeWithConfig is like Serve but lets the caller tune the server.
+func ServeWithConfig(port int, handler Handler, config Config) (*Server, error) {
 	addr := fmt.Sprintf(":%d", port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -43,79 +182,291 @@ func Serve(port int, handler Handler) (*Server, error) {
 	server := &Server{
 		listener: listener,
 		handler:  handler, // Store the provided handler.
+		config:   config,
 	}
 	log.Printf("Listening on :%d...", port)
 	go server.listen()
 	return server, nil
 }
 
+// connectionHeaders sets the Connection header so the client knows whether
+// the socket stays open after this response.
+func connectionHeaders(h *headers.Headers, keepAlive bool) *headers.Headers {
+	if keepAlive {
+		h.Set("Connection", "keep-alive")
+	} else {
+		h.Set("Connection", "close")
+	}
+	return h
+}
+
 // writeErrorResponse is a helper to keep error handling DRY.
-func (s *Server) writeErrorResponse(conn net.Conn, err *HandlerError) {
+// For a HEAD request (head) the body is left out, but Content-Length still
+// gives its size. It returns false if the response could not be written.
+func (s *Server) writeErrorResponse(conn net.Conn, err *HandlerError, keepAlive, head bool) bool {
+	// An error response is the final response, so it needs a final status:
+	// one that can't go on a status line, or a 1xx that would leave the
+	// client waiting for the real answer, becomes a 500 (as it does in
+	// ResponseWriter.WriteHeader).
+	if !err.StatusCode.IsValid() || err.StatusCode.IsInformational() {
+		log.Printf("Invalid error status code %d, sending 500 instead", err.StatusCode)
+		fixed := *err
+		fixed.StatusCode = response.StatusInternalServerError
+		err = &fixed
+	}
 	// Write the status line for the error (e.g., 400 or 500).
 	if writeErr := response.WriteStatusLine(conn, err.StatusCode); writeErr != nil {
 		log.Printf("Error writing error status line: %v", writeErr)
-		return
+		return false
 	}
-	// Create headers with the error message's length.
-	headers := response.GetDefaultHeaders(len(err.Message))
-	if writeErr := response.WriteHeaders(conn, headers); writeErr != nil {
+	// Create headers with the error body's length and type.
+	contentType, body := err.body(s.config.ProblemJSON)
+	h := connectionHeaders(response.GetDefaultHeaders(len(body)), keepAlive)
+	h.Set("Content-Type", contentType)
+	if !err.StatusCode.AllowsBody() {
+		// A 204 or 304 ends with its headers; the message can't be sent.
+		h.Del("content-length")
+		h.Del("content-type")
+	}
+	if writeErr := response.WriteHeaders(conn, h); writeErr != nil {
 		log.Printf("Error writing error headers: %v", writeErr)
-		return
+		return false
+	}
+	if !err.StatusCode.AllowsBody() || len(body) == 0 || head {
+		return true
 	}
 	// Write the error message as the body.
-	if _, writeErr := conn.Write([]byte(err.Message)); writeErr != nil {
+	if _, writeErr := conn.Write(body); writeErr != nil {
 		log.Printf("Error writing error body: %v", writeErr)
+		return false
 	}
+	return true
 }
 
-// handle now orchestrates the request parsing and handler execution.
-func (s *Server) handle(conn net.Conn) {
+// wantsKeepAlive reports whether the connection should stay open after
+// answering req. HTTP/1.1 connections are persistent unless either side sends
+// "Connection: close"; HTTP/1.0 connections close unless the client asks for
+// "Connection: keep-alive".
+func wantsKeepAlive(req *request.Request) bool {
+	keepAlive := req.RequestLine.HttpVersion == "1.1"
+	// Connection is a comma-separated list of tokens, e.g. "keep-alive, Upgrade".
+	for _, token := range strings.Split(req.Headers.Get("Connection"), ",") {
+		switch strings.ToLower(strings.TrimSpace(token)) {
+		case "close":
+			return false
+		case "keep-alive":
+			keepAlive = true
+		}
+	}
+	return keepAlive
+}
+
+// isTimeout reports whether err came from a connection deadline firing.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// handle serves every request sent on conn until the client asks to close
+// the connection, the idle timeout fires, or something goes wrong.
+func (s *Server) handle(conn *trackedConn) {
+	defer s.untrackConn(conn)
 	defer conn.Close()
+	defer s.recoverConn(conn)
+
+	// The reader lives as long as the connection so that bytes belonging to
+	// a pipelined request are carried over instead of lost. Requests are
+	// answered one at a time, which keeps responses in request order.
+	reader := request.NewReader(conn)
+	reader.Limits = s.config.limits()
+	for first := true; ; first = false {
+		// Step 1: Wait for the next request, but not forever. A fresh
+		// connection gets no longer to start its request than to send the
+		// headers; later ones may idle for IdleTimeout.
+		wait := s.config.idleTimeout()
+		if first {
+			wait = s.config.headerTimeout()
+		}
+		conn.state.Store(stateIdle)
+		conn.SetReadDeadline(time.Now().Add(wait))
+		conn.SetWriteDeadline(time.Time{})
+		req, err := s.readRequest(conn, reader)
+		if err != nil {
+			// The client hung up or went quiet between requests, or Shutdown
+			// closed the idle connection; nothing to answer.
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if isTimeout(err) {
+				// A client that started a request and then stalled is told so.
+				if conn.state.Load() == stateActive {
+					s.writeErrorResponse(conn, &HandlerError{
+						StatusCode: response.StatusRequestTimeout,
+						Message:    "Request Timeout\n",
+					}, false, false)
+				}
+				return
+			}
+			// For a parsing error, we answer with the status the parser
+			// suggests (400 Bad Request unless it knows better). We can't
+			// trust the framing of anything that follows, so the connection
+			// is closed.
+			herr := readErrorResponse(err)
+			log.Printf("Rejected request (%d %s) from %s: %v", herr.StatusCode, response.StatusText(herr.StatusCode), conn.RemoteAddr(), err)
+			s.writeErrorResponse(conn, herr, false, false)
+			return
+		}
+		conn.SetWriteDeadline(deadline(time.Now(), s.config.WriteTimeout))
 
-	// Step 1: Parse the incoming request.
-	req, err := request.RequestFromReader(conn)
+		// Step 2: Serve the request and decide whether to keep going. A
+		// server that is shutting down finishes this response and hangs up.
+		keepAlive := wantsKeepAlive(req) && !s.isClosed.Load()
+		if !s.serve(conn, req, keepAlive) {
+			return
+		}
+	}
+}
+
+// readErrorResponse picks the error response for a request that couldn't
+// be read. Parse errors carry the status to answer with; anything else is
+// a 400.
+func readErrorResponse(err error) *HandlerError {
+	status := response.StatusBadRequest
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		status = response.StatusCode(parseErr.Status)
+	}
+	return &HandlerError{StatusCode: status, Message: response.StatusText(status) + "\n"}
+}
+
+// readRequest reads the next request, leaving the body on the connection if
+// the server streams request bodies.
+func (s *Server) readRequest(conn *trackedConn, reader *request.Reader) (*request.Request, error) {
+	req, err := reader.ReadRequestHeaders()
 	if err != nil {
-		log.Printf("Error reading request: %v", err)
-		// For a parsing error, we send a 400 Bad Request.
-		s.writeErrorResponse(conn, &HandlerError{
-			StatusCode: response.StatusBadRequest,
-			Message:    "Bad Request\n",
-		})
-		return
+		return nil, err
+	}
+	// A pipelined request may come entirely from buffered bytes, so the
+	// connection is marked busy here as well as on read.
+	if conn.state.Swap(stateActive) == stateIdle {
+		conn.startRequest()
+	}
+
+	// The body gets whatever is left of ReadTimeout.
+	conn.SetReadDeadline(deadline(conn.requestStart, s.config.ReadTimeout))
+	if s.config.StreamRequestBody {
+		return req, nil
 	}
+	// The body is read right here, so a client waiting for permission to
+	// send it has to get it now.
+	if wantsContinue(req) {
+		if err := response.WriteInformational(conn, response.StatusContinue, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
 
-	// Step 2: Create a buffer to capture the response body from the handler.
-	responseBody := new(bytes.Buffer)
+// discardBody throws away whatever the handler left unread of a streamed
+// request body. It returns false if the connection can't be reused, either
+// because the body was too big to skip or because it was malformed.
+func discardBody(req *request.Request) bool {
+	body := req.BodyReader()
+	_, err := io.CopyN(io.Discard, body, maxBodyDrain+1)
+	if err == io.EOF {
+		return body.Close() == nil
+	}
+	// A handler that closed the body had Close drain it already; the
+	// connection is fine as long as that got to the end.
+	if errors.Is(err, request.ErrBodyClosed) && req.BodyDone() {
+		return true
+	}
+	// Not closing the body here is deliberate: Close drains whatever is
+	// left, which is exactly what the cap is there to avoid. The connection
+	// is closed instead.
+	if err == nil {
+		log.Printf("Closing connection: more than %d bytes of request body left unread", maxBodyDrain)
+	} else {
+		log.Printf("Error discarding request body: %v", err)
+	}
+	return false
+}
 
-	// Step 3: Call the application's handler logic.
-	handlerErr := s.handler(responseBody, req)
+// serve runs the handler for a single request and writes its response.
+// It returns true if the connection can be used for another request.
+func (s *Server) serve(conn net.Conn, req *request.Request, keepAlive bool) bool {
+	// Step 1: Create the writer the handler builds its response with.
+	w := newResponseWriter(s, conn, req, keepAlive)
+	if s.config.EagerContinue {
+		if err := w.sendContinue(); err != nil {
+			log.Printf("Error writing 100 Continue: %v", err)
+			return false
+		}
+	}
 
-	// Step 4: Check if the handler returned an error.
-	if handlerErr != nil {
-		// If so, write the specific error response.
-		s.writeErrorResponse(conn, handlerErr)
-		return
+	// Step 2: Call the application's handler logic. A panic is recovered
+	// and answered with a 500, and the connection is closed afterwards.
+	handlerErr, panicked := s.runHandler(w, req)
+	if panicked {
+		w.keepAlive = false
+	}
+	// A multipart form parsed along the way, perhaps implicitly by
+	// FormValue or FormFile, may have spilled files to disk. Nothing can
+	// read them once the handler is done, so they are removed here.
+	if req.MultipartForm != nil {
+		if err := req.MultipartForm.RemoveAll(); err != nil {
+			log.Printf("Error removing multipart temp files: %v", err)
+		}
 	}
 
-	// Step 5: If the handler succeeded, write the successful (200 OK) response.
-	// Write the status line.
-	if err := response.WriteStatusLine(conn, response.StatusOK); err != nil {
-		log.Printf("Error writing status line: %v", err)
-		return
+	// Step 3: Check if the handler returned an error.
+	if handlerErr != nil {
+		if w.headerSent {
+			// Too late to change the status; all we can do is hang up so the
+			// client doesn't mistake a truncated response for a complete one.
+			log.Printf("Handler error after response was started: %v", handlerErr)
+			return false
+		}
+		// Otherwise the buffered body is dropped in favour of the error
+		// response. A client that was never told to send its body might
+		// still send it, so its connection is closed after the error.
+		w.keepAlive = w.keepAlive && !s.isClosed.Load() && !w.bodyWithheld()
+		if !s.writeErrorResponse(conn, handlerErr, w.keepAlive, w.head) {
+			return false
+		}
+	} else {
+		// Step 4: Send whatever the handler left buffered.
+		if err := w.finish(); err != nil {
+			log.Printf("Error writing response: %v", err)
+			if w.headerSent || !errors.Is(err, response.ErrInvalidHeaderField) {
+				return false
+			}
+			// The handler set a header that can't be sent, e.g. a value with
+			// a CRLF in it. Nothing is on the wire yet, so answer with a 500
+			// rather than a malformed response.
+			if !s.writeErrorResponse(conn, &HandlerError{
+				StatusCode: response.StatusInternalServerError,
+				Message:    response.StatusText(response.StatusInternalServerError) + "\n",
+			}, w.keepAlive, w.head) {
+				return false
+			}
+		}
 	}
-	// Create headers with the correct length from the buffer.
-	headers := response.GetDefaultHeaders(responseBody.Len())
-	if err := response.WriteHeaders(conn, headers); err != nil {
-		log.Printf("Error writing headers: %v", err)
-		return
+	if !w.keepAlive {
+		return false
 	}
-	// Write the body that the handler generated.
-	if _, err := conn.Write(responseBody.Bytes()); err != nil {
-		log.Printf("Error writing response body: %v", err)
+
+	// Step 5: Skip over any body the handler didn't read so the next
+	// request starts in the right place.
+	if s.config.StreamRequestBody {
+		return discardBody(req)
 	}
+	return true
 }
 
-// listen and Close methods remain unchanged.
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
@@ -127,10 +478,20 @@ func (s *Server) listen() {
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
-		go s.handle(conn)
+		// The connection is tracked before its goroutine starts, so that
+		// Shutdown can't miss one accepted just before the listener closed.
+		if c := s.trackConn(conn); c != nil {
+			go s.handle(c)
+		}
 	}
 }
+
+// Close stops the server immediately, closing the listener and every open
+// connection, including ones in the middle of a request. Use Shutdown to let
+// in-flight requests finish.
 func (s *Server) Close() error {
 	s.isClosed.Store(true)
-	return s.listener.Close()
-}
\ No newline at end of file
+	err := s.listener.Close()
+	s.closeConns(false)
+	return err
+}
@@ -0,0 +1,1437 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"HttpFromTcp/internal/request"
+	"HttpFromTcp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResponse is a parsed HTTP response as seen by a client.
+type testResponse struct {
+	StatusLine string
+	Headers    map[string]string
+	Body       string
+	Trailers   map[string]string
+}
+
+// readResponse reads a single response from br, framed either by
+// Content-Length or by chunked encoding, or ending with the headers for
+// statuses that have no body.
+func readResponse(t *testing.T, br *bufio.Reader) testResponse {
+	t.Helper()
+	resp := readResponseHead(t, br)
+	// 1xx, 204 and 304 responses end with the headers.
+	if code := resp.StatusLine[9:12]; code[0] == '1' || code == "204" || code == "304" {
+		return resp
+	}
+	if resp.Headers["transfer-encoding"] == "chunked" {
+		resp.Body, resp.Trailers = readChunkedBody(t, br)
+		return resp
+	}
+	length, err := strconv.Atoi(resp.Headers["content-length"])
+	require.NoError(t, err)
+	body := make([]byte, length)
+	_, err = io.ReadFull(br, body)
+	require.NoError(t, err)
+	resp.Body = string(body)
+	return resp
+}
+
+// readResponseHead reads the status line and headers of a response, which
+// is all there is of a response to HEAD.
+func readResponseHead(t *testing.T, br *bufio.Reader) testResponse {
+	t.Helper()
+	statusLine, err := br.ReadString('\n')
+	require.NoError(t, err)
+	resp := testResponse{
+		StatusLine: strings.TrimRight(statusLine, "\r\n"),
+		Headers:    map[string]string{},
+	}
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		require.True(t, ok, "malformed header line %q", line)
+		resp.Headers[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+	return resp
+}
+
+// readChunkedBody decodes a chunked body and its trailer section.
+func readChunkedBody(t *testing.T, br *bufio.Reader) (string, map[string]string) {
+	t.Helper()
+	var body strings.Builder
+	for {
+		sizeLine, err := br.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimRight(sizeLine, "\r\n"), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(br, chunk)
+		require.NoError(t, err)
+		body.Write(chunk[:size])
+	}
+	trailers := map[string]string{}
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return body.String(), trailers
+		}
+		key, value, ok := strings.Cut(line, ":")
+		require.True(t, ok, "malformed trailer line %q", line)
+		trailers[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+}
+
+// startConn runs the server's connection handler on one end of an in-memory
+// pipe and returns the client end.
+func startConn(t *testing.T, handler Handler) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	return startConnWithConfig(t, handler, Config{})
+}
+
+// startConnWithConfig is startConn for a server with the given config.
+func startConnWithConfig(t *testing.T, handler Handler, config Config) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	client, serverConn := net.Pipe()
+	s := &Server{handler: handler, config: config}
+	done := make(chan struct{})
+	go func() {
+		s.handle(s.trackConn(serverConn))
+		close(done)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client, done
+}
+
+// waitClosed fails the test if the server doesn't hang up on the client.
+func waitClosed(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not close the connection")
+	}
+}
+
+func echoTargetHandler(w ResponseWriter, req *request.Request) *HandlerError {
+	fmt.Fprintf(w, "you asked for %s", req.RequestLine.RequestTarget)
+	return nil
+}
+
+func TestServer_KeepAlive(t *testing.T) {
+	t.Run("Extra CRLF after a body is ignored", func(t *testing.T) {
+		client, _ := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("POST /a HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi\r\nGET /b HTTP/1.1\r\n\r\n"))
+		assert.Equal(t, "you asked for /a", readResponse(t, br).Body)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "you asked for /b", resp.Body)
+	})
+
+	t.Run("HTTP/1.1 connection stays open by default", func(t *testing.T) {
+		client, done := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		for _, target := range []string{"/one", "/two", "/three"} {
+			_, err := fmt.Fprintf(client, "GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", target)
+			require.NoError(t, err)
+			resp := readResponse(t, br)
+			assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+			assert.Equal(t, "keep-alive", resp.Headers["connection"])
+			assert.Equal(t, "you asked for "+target, resp.Body)
+		}
+
+		client.Close()
+		waitClosed(t, done)
+	})
+
+	t.Run("HTTP/1.1 client asks to close", func(t *testing.T) {
+		client, done := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+
+	t.Run("HTTP/1.0 closes by default", func(t *testing.T) {
+		client, done := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+
+	t.Run("HTTP/1.0 client asks for keep-alive", func(t *testing.T) {
+		client, done := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		for i := 0; i < 2; i++ {
+			_, err := client.Write([]byte("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+			require.NoError(t, err)
+			resp := readResponse(t, br)
+			assert.Equal(t, "keep-alive", resp.Headers["connection"])
+		}
+
+		client.Close()
+		waitClosed(t, done)
+	})
+
+	t.Run("Pipelined requests are answered in order", func(t *testing.T) {
+		client, done := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET /a HTTP/1.1\r\n\r\n" +
+			"GET /b HTTP/1.1\r\n\r\n" +
+			"GET /c HTTP/1.1\r\nConnection: close\r\n\r\n"))
+
+		for _, target := range []string{"/a", "/b", "/c"} {
+			resp := readResponse(t, br)
+			assert.Equal(t, "you asked for "+target, resp.Body)
+		}
+		waitClosed(t, done)
+	})
+
+	t.Run("Malformed request closes the connection", func(t *testing.T) {
+		client, done := startConn(t, echoTargetHandler)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("get / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 400 Bad Request", resp.StatusLine)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+}
+
+func TestServer_Middleware(t *testing.T) {
+	// header returns middleware that records its name in X-Order.
+	header := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w ResponseWriter, req *request.Request) *HandlerError {
+				w.Header().Set("X-Order", w.Header().Get("X-Order")+name)
+				return next(w, req)
+			}
+		}
+	}
+
+	t.Run("Chain runs the first middleware outermost", func(t *testing.T) {
+		client, _ := startConn(t, Chain(header("a"), header("b"))(echoTargetHandler))
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "ab", resp.Headers["x-order"])
+	})
+
+	t.Run("Use wraps the server's handler in call order", func(t *testing.T) {
+		client, serverConn := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		s := &Server{handler: echoTargetHandler}
+		s.Use(header("a"))
+		s.Use(header("b"), header("c"))
+		go s.handle(s.trackConn(serverConn))
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "abc", resp.Headers["x-order"])
+		assert.Equal(t, "you asked for /", resp.Body)
+	})
+}
+
+func TestServer_ResponseWriter(t *testing.T) {
+	t.Run("Custom status and headers are buffered", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Custom", "yes")
+			w.WriteHeader(response.StatusCreated)
+			fmt.Fprint(w, `{"id":1}`)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("POST /things HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 201 Created", resp.StatusLine)
+		assert.Equal(t, "application/json", resp.Headers["content-type"])
+		assert.Equal(t, "yes", resp.Headers["x-custom"])
+		assert.Equal(t, "8", resp.Headers["content-length"])
+		assert.Equal(t, `{"id":1}`, resp.Body)
+	})
+
+	t.Run("No Content has no body", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.WriteHeader(response.StatusNoContent)
+			_, err := fmt.Fprint(w, "ignored")
+			assert.ErrorIs(t, err, ErrBodyNotAllowed)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("DELETE /things/1 HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 204 No Content", resp.StatusLine)
+		assert.NotContains(t, resp.Headers, "content-length")
+		assert.NotContains(t, resp.Headers, "content-type")
+		assert.NotContains(t, resp.Headers, "transfer-encoding")
+		// The connection is still usable: the next response follows directly.
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 204 No Content", resp.StatusLine)
+	})
+
+	t.Run("Not Modified keeps the handler's Content-Length", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Content-Length", "42")
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(response.StatusNotModified)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 304 Not Modified", resp.StatusLine)
+		assert.Equal(t, "42", resp.Headers["content-length"])
+		assert.Equal(t, `"v1"`, resp.Headers["etag"])
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 304 Not Modified", resp.StatusLine)
+	})
+
+	t.Run("Handler errors can use any status", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			switch req.RequestLine.RequestTarget {
+			case "/missing":
+				return &HandlerError{StatusCode: response.StatusNotFound, Message: "no such thing\n"}
+			case "/busy":
+				return &HandlerError{StatusCode: response.StatusServiceUnavailable, Message: "try later\n"}
+			default:
+				return &HandlerError{StatusCode: response.StatusTooManyRequests, Message: "slow down\n"}
+			}
+		})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET /missing HTTP/1.1\r\n\r\nGET /busy HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 404 Not Found", resp.StatusLine)
+		assert.Equal(t, "no such thing\n", resp.Body)
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 503 Service Unavailable", resp.StatusLine)
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 429 Too Many Requests", resp.StatusLine)
+		assert.Equal(t, "slow down\n", resp.Body)
+	})
+
+	t.Run("Handler errors without a final status are sent as 500", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			if req.RequestLine.RequestTarget == "/hints" {
+				return &HandlerError{StatusCode: response.StatusEarlyHints, Message: "too early\n"}
+			}
+			return &HandlerError{Message: "no status\n"}
+		})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\nGET /hints HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+		assert.Equal(t, "no status\n", resp.Body)
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+		assert.Equal(t, "too early\n", resp.Body)
+		assert.Equal(t, "keep-alive", resp.Headers["connection"])
+	})
+
+	t.Run("Declared Content-Length is streamed", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Content-Length", "10")
+			fmt.Fprint(w, "hello")
+			fmt.Fprint(w, "world")
+			_, err := fmt.Fprint(w, "!")
+			assert.ErrorIs(t, err, ErrBodyTooLong)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "helloworld", resp.Body)
+	})
+
+	t.Run("Short streamed body closes the connection", func(t *testing.T) {
+		client, done := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Content-Length", "10")
+			w.WriteHeader(response.StatusOK)
+			return nil
+		})
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		go io.Copy(io.Discard, client)
+		waitClosed(t, done)
+	})
+
+	t.Run("Handler error replaces buffered body", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			fmt.Fprint(w, "partial output")
+			return &HandlerError{StatusCode: response.StatusBadRequest, Message: "nope\n"}
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 400 Bad Request", resp.StatusLine)
+		assert.Equal(t, "keep-alive", resp.Headers["connection"])
+		assert.Equal(t, "nope\n", resp.Body)
+	})
+
+	t.Run("Handler can close the connection", func(t *testing.T) {
+		client, done := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Connection", "close")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+}
+
+func TestServer_InvalidHeaders(t *testing.T) {
+	t.Run("CRLF in a header value becomes a 500", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			// Echoing the query into a header is how response splitting starts.
+			w.Header().Set("X-Echo", "hi\r\nSet-Cookie: session=stolen")
+			fmt.Fprint(w, "body")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+		assert.NotContains(t, resp.Headers, "set-cookie")
+		assert.NotContains(t, resp.Headers, "x-echo")
+		assert.Equal(t, "keep-alive", resp.Headers["connection"])
+
+		// Nothing leaked onto the wire, so the connection is still usable.
+		resp = readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+	})
+
+	t.Run("Invalid name with a declared Content-Length", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Bad Name", "x")
+			w.Header().Set("Content-Length", "5")
+			w.WriteHeader(response.StatusOK)
+			fmt.Fprint(w, "hello")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+		assert.Equal(t, "Internal Server Error\n", resp.Body)
+	})
+}
+
+func TestServer_ChunkedResponses(t *testing.T) {
+	t.Run("Handler asks for chunked encoding", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Transfer-Encoding", "chunked")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "line %d\n", i)
+			}
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET /logs HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "chunked", resp.Headers["transfer-encoding"])
+		assert.NotContains(t, resp.Headers, "content-length")
+		assert.Equal(t, "line 0\nline 1\nline 2\n", resp.Body)
+
+		// The connection is still usable afterwards.
+		_, err = client.Write([]byte("GET /logs HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp = readResponse(t, br)
+		assert.Equal(t, "line 0\nline 1\nline 2\n", resp.Body)
+	})
+
+	t.Run("Large body switches to chunked", func(t *testing.T) {
+		payload := strings.Repeat("x", maxBufferedBody+1)
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			fmt.Fprint(w, payload[:10])
+			fmt.Fprint(w, payload[10:])
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "chunked", resp.Headers["transfer-encoding"])
+		assert.Equal(t, payload, resp.Body)
+	})
+
+	t.Run("HTTP/1.0 client gets a close-delimited body", func(t *testing.T) {
+		client, done := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Transfer-Encoding", "chunked")
+			fmt.Fprint(w, "streamed")
+			return nil
+		})
+
+		_, err := client.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		raw, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.NotContains(t, string(raw), "Transfer-Encoding")
+		assert.Contains(t, string(raw), "Connection: close\r\n")
+		assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\nstreamed"))
+		waitClosed(t, done)
+	})
+}
+
+func TestServer_Trailers(t *testing.T) {
+	t.Run("Announced trailers follow the body", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Trailer", "Server-Timing")
+			fmt.Fprint(w, "report")
+			w.Trailer().Set("Server-Timing", "db;dur=53")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "chunked", resp.Headers["transfer-encoding"])
+		assert.Equal(t, "Server-Timing", resp.Headers["trailer"])
+		assert.Equal(t, "report", resp.Body)
+		assert.Equal(t, "db;dur=53", resp.Trailers["server-timing"])
+	})
+
+	t.Run("Unannounced trailers are announced by the server", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Trailer().Set("Digest", "sha-256=abc")
+			w.Trailer().Set("Content-MD5", "xyz")
+			fmt.Fprint(w, "payload")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "Content-Md5, Digest", resp.Headers["trailer"])
+		assert.Equal(t, "sha-256=abc", resp.Trailers["digest"])
+	})
+
+	t.Run("Disallowed trailers are dropped before they are announced", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Trailer().Set("Content-Length", "5")
+			w.Trailer().Set("X-Checksum", "abc")
+			fmt.Fprint(w, "payload")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "X-Checksum", resp.Headers["trailer"])
+		assert.Equal(t, "payload", resp.Body)
+		assert.Equal(t, map[string]string{"x-checksum": "abc"}, resp.Trailers)
+	})
+
+	t.Run("Disallowed trailers set after streaming started still end the body", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Transfer-Encoding", "chunked")
+			fmt.Fprint(w, "payload")
+			w.Trailer().Set("Transfer-Encoding", "gzip")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "payload", resp.Body)
+		assert.Empty(t, resp.Trailers)
+		// The connection is still in sync for the next response.
+		assert.Equal(t, "HTTP/1.1 200 OK", readResponse(t, br).StatusLine)
+	})
+
+	t.Run("Malformed trailers are dropped", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Transfer-Encoding", "chunked")
+			fmt.Fprint(w, "payload")
+			w.Trailer().Set("X-Checksum", "abc\r\n\r\ninjected")
+			w.Trailer().Set("Digest", "sha-256=abc")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "payload", resp.Body)
+		assert.Equal(t, map[string]string{"digest": "sha-256=abc"}, resp.Trailers)
+	})
+
+	t.Run("HTTP/1.0 clients get no trailers", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Trailer().Set("Digest", "sha-256=abc")
+			fmt.Fprint(w, "payload")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "7", resp.Headers["content-length"])
+		assert.Equal(t, "payload", resp.Body)
+	})
+}
+
+func TestServer_StreamRequestBody(t *testing.T) {
+	t.Run("Handler reads the body as a stream", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			assert.Nil(t, req.Body)
+			n, err := io.Copy(io.Discard, req.BodyReader())
+			require.NoError(t, err)
+			fmt.Fprintf(w, "received %d bytes", n)
+			return nil
+		}, Config{StreamRequestBody: true})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"400\r\n" + strings.Repeat("a", 1024) + "\r\n0\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "received 1024 bytes", resp.Body)
+	})
+
+	t.Run("Unread body is skipped before the next request", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, echoTargetHandler, Config{StreamRequestBody: true})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("POST /first HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\n\r\n"))
+		assert.Equal(t, "you asked for /first", readResponse(t, br).Body)
+		assert.Equal(t, "you asked for /second", readResponse(t, br).Body)
+	})
+
+	t.Run("Handler closes the body, the next request is still served", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			body := req.BodyReader()
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			fmt.Fprintf(w, "got %s", data)
+			return nil
+		}, Config{StreamRequestBody: true})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("POST /a HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /b HTTP/1.1\r\nContent-Length: 5\r\n\r\nworld"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "got hello", resp.Body)
+		assert.Equal(t, "keep-alive", resp.Headers["connection"])
+		assert.Equal(t, "got world", readResponse(t, br).Body)
+	})
+
+	t.Run("Large unread body closes the connection", func(t *testing.T) {
+		client, done := startConnWithConfig(t, echoTargetHandler, Config{StreamRequestBody: true})
+		br := bufio.NewReader(client)
+
+		// Only part of the declared body is sent, and the client then goes
+		// quiet: the server must give up after maxBodyDrain bytes instead
+		// of waiting for the rest.
+		go fmt.Fprintf(client, "POST / HTTP/1.1\r\nContent-Length: %d\r\n\r\n%s",
+			4*maxBodyDrain, strings.Repeat("a", maxBodyDrain+4096))
+		readResponse(t, br)
+		waitClosed(t, done)
+	})
+}
+
+func TestServer_MultipartCleanup(t *testing.T) {
+	t.Run("Spilled upload leaves no file behind", func(t *testing.T) {
+		tmpfile := make(chan string, 1)
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			require.NoError(t, req.ParseMultipartForm(100))
+			fh, err := req.FormFile("big")
+			require.NoError(t, err)
+			f, err := fh.Open()
+			require.NoError(t, err)
+			defer f.Close()
+			// A part bigger than maxMemory is stored in a temporary file.
+			file, ok := f.(*os.File)
+			require.True(t, ok, "expected the upload to spill to disk")
+			tmpfile <- file.Name()
+			fmt.Fprintf(w, "got %d bytes", fh.Size)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		body := "--XyZ\r\nContent-Disposition: form-data; name=\"big\"; filename=\"b.bin\"\r\n\r\n" +
+			strings.Repeat("x", 1000) + "\r\n--XyZ--\r\n"
+		go fmt.Fprintf(client, "POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=XyZ\r\n"+
+			"Content-Length: %d\r\n\r\n%s", len(body), body)
+		assert.Equal(t, "got 1000 bytes", readResponse(t, br).Body)
+
+		_, err := os.Stat(<-tmpfile)
+		assert.True(t, os.IsNotExist(err), "temporary file should be removed")
+	})
+}
+
+func TestServer_ExpectContinue(t *testing.T) {
+	const head = "POST /upload HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"
+	stream := Config{StreamRequestBody: true}
+	echoBody := func(w ResponseWriter, req *request.Request) *HandlerError {
+		body, err := io.ReadAll(req.BodyReader())
+		require.NoError(t, err)
+		fmt.Fprintf(w, "got %s", body)
+		return nil
+	}
+
+	t.Run("100 Continue is sent when the handler reads the body", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, echoBody, stream)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte(head))
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 100 Continue", readResponse(t, br).StatusLine)
+
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "got hello", resp.Body)
+		assert.Equal(t, "keep-alive", resp.Headers["connection"])
+	})
+
+	t.Run("Handler rejects before the body is sent", func(t *testing.T) {
+		client, done := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.WriteHeader(response.StatusContentTooLarge)
+			return nil
+		}, stream)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte(head))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 413 Content Too Large", resp.StatusLine)
+		// The body never came, so the connection can't be reused.
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+
+	t.Run("Handler error rejects before the body is sent", func(t *testing.T) {
+		client, done := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			return &HandlerError{StatusCode: response.StatusExpectationFailed, Message: "no uploads here\n"}
+		}, stream)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte(head))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 417 Expectation Failed", resp.StatusLine)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+
+	t.Run("EagerContinue answers before the handler runs", func(t *testing.T) {
+		ran := make(chan struct{})
+		client, _ := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			close(ran)
+			return echoBody(w, req)
+		}, Config{StreamRequestBody: true, EagerContinue: true})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte(head))
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 100 Continue", readResponse(t, br).StatusLine)
+		<-ran
+
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, "got hello", readResponse(t, br).Body)
+	})
+
+	t.Run("Buffered bodies get 100 Continue right away", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			fmt.Fprintf(w, "got %s", req.Body)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte(head))
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 100 Continue", readResponse(t, br).StatusLine)
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, "got hello", readResponse(t, br).Body)
+	})
+
+	t.Run("Body sent without waiting gets no 100", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, echoBody, stream)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte(head + "hello"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "got hello", resp.Body)
+	})
+
+	t.Run("HTTP/1.0 clients get no 100", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, echoBody, stream)
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("POST / HTTP/1.0\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello"))
+		resp := readResponse(t, br)
+		// The first thing back is the final response.
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "got hello", resp.Body)
+	})
+
+	t.Run("Handler can send other informational responses", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.WriteHeader(response.StatusProcessing)
+			w.WriteHeader(response.StatusAccepted)
+			fmt.Fprint(w, "queued")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 102 Processing", readResponse(t, br).StatusLine)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 202 Accepted", resp.StatusLine)
+		assert.Equal(t, "queued", resp.Body)
+	})
+
+	runRejectCases(t, Config{}, []rejectCase{
+		{
+			name:       "Unknown expectation",
+			request:    "POST / HTTP/1.1\r\nContent-Length: 5\r\nExpect: 200-ok\r\n\r\nhello",
+			statusLine: "HTTP/1.1 417 Expectation Failed",
+		},
+	})
+}
+
+func TestServer_InformationalResponses(t *testing.T) {
+	earlyHints := func(w ResponseWriter, req *request.Request) *HandlerError {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(response.StatusEarlyHints)
+		w.Header().Add("Link", "</app.js>; rel=preload; as=script")
+		w.WriteHeader(response.StatusEarlyHints)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html></html>")
+		return nil
+	}
+
+	t.Run("Several Early Hints before the final response", func(t *testing.T) {
+		client, _ := startConn(t, earlyHints)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+
+		// Each 103 carries the Link headers set so far.
+		first := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 103 Early Hints", first.StatusLine)
+		assert.Equal(t, "</style.css>; rel=preload; as=style", first.Headers["link"])
+		second := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 103 Early Hints", second.StatusLine)
+		assert.Equal(t, "</app.js>; rel=preload; as=script", second.Headers["link"])
+		assert.NotContains(t, second.Headers, "content-length")
+		assert.NotContains(t, second.Headers, "connection")
+
+		final := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", final.StatusLine)
+		assert.Equal(t, "text/html", final.Headers["content-type"])
+		assert.Equal(t, "<html></html>", final.Body)
+	})
+
+	t.Run("Exactly one final response", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.WriteHeader(response.StatusEarlyHints)
+			w.WriteHeader(response.StatusCreated)
+			// Too late for either of these.
+			w.WriteHeader(response.StatusEarlyHints)
+			w.WriteHeader(response.StatusOK)
+			fmt.Fprint(w, "done")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, "HTTP/1.1 103 Early Hints", readResponse(t, br).StatusLine)
+			resp := readResponse(t, br)
+			assert.Equal(t, "HTTP/1.1 201 Created", resp.StatusLine)
+			assert.Equal(t, "done", resp.Body)
+		}
+	})
+
+	t.Run("Only informational responses still get a final one", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.WriteHeader(response.StatusEarlyHints)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 103 Early Hints", readResponse(t, br).StatusLine)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "0", resp.Headers["content-length"])
+	})
+
+	t.Run("Handler error after Early Hints", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Add("Link", "</style.css>; rel=preload")
+			w.WriteHeader(response.StatusEarlyHints)
+			return &HandlerError{StatusCode: response.StatusNotFound, Message: "gone\n"}
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 103 Early Hints", readResponse(t, br).StatusLine)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 404 Not Found", resp.StatusLine)
+		assert.Equal(t, "gone\n", resp.Body)
+	})
+
+	t.Run("Switching Protocols is not sent", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.WriteHeader(response.StatusSwitchingProtocols)
+			fmt.Fprint(w, "still http")
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "still http", resp.Body)
+	})
+
+	t.Run("HTTP/1.0 clients only get the final response", func(t *testing.T) {
+		client, _ := startConn(t, earlyHints)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", resp.StatusLine)
+		assert.Equal(t, "<html></html>", resp.Body)
+	})
+}
+
+func TestServer_Head(t *testing.T) {
+	page := func(w ResponseWriter, req *request.Request) *HandlerError {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "<html>hello</html>")
+		return nil
+	}
+
+	t.Run("Same headers as GET and no body", func(t *testing.T) {
+		client, _ := startConn(t, page)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\nHEAD / HTTP/1.1\r\n\r\nGET /next HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		get := readResponse(t, br)
+		head := readResponseHead(t, br)
+		assert.Equal(t, get.StatusLine, head.StatusLine)
+		assert.Equal(t, get.Headers, head.Headers)
+		assert.Equal(t, "18", head.Headers["content-length"])
+
+		// Had any body bytes followed the HEAD response, this would be
+		// reading them instead.
+		next := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", next.StatusLine)
+		assert.Equal(t, "<html>hello</html>", next.Body)
+	})
+
+	t.Run("Plain handler sees HEAD and OPTIONS * as sent", func(t *testing.T) {
+		// Treating HEAD as GET and answering OPTIONS is router.Router's
+		// job; without it the handler gets the request untouched, and the
+		// server only drops the body of the HEAD response.
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			fmt.Fprintf(w, "%s %s", req.RequestLine.Method, req.RequestLine.RequestTarget)
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("HEAD / HTTP/1.1\r\n\r\nOPTIONS * HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		head := readResponseHead(t, br)
+		assert.Equal(t, "HTTP/1.1 200 OK", head.StatusLine)
+		assert.Equal(t, "6", head.Headers["content-length"])
+		options := readResponse(t, br)
+		assert.Equal(t, "OPTIONS *", options.Body)
+		assert.Empty(t, options.Headers["allow"])
+	})
+
+	t.Run("Large body keeps the GET framing", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Trailer().Set("X-Checksum", "abc")
+			fmt.Fprint(w, strings.Repeat("x", maxBufferedBody+1))
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("HEAD / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		head := readResponseHead(t, br)
+		assert.Equal(t, "chunked", head.Headers["transfer-encoding"])
+		assert.Equal(t, "X-Checksum", head.Headers["trailer"])
+
+		get := readResponse(t, br)
+		assert.Equal(t, head.Headers, get.Headers)
+		assert.Len(t, get.Body, maxBufferedBody+1)
+		assert.Equal(t, "abc", get.Trailers["x-checksum"])
+	})
+
+	t.Run("Declared Content-Length without a body", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Content-Length", "1000")
+			if req.RequestLine.Method != "HEAD" {
+				fmt.Fprint(w, strings.Repeat("x", 1000))
+			}
+			return nil
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("HEAD / HTTP/1.1\r\n\r\nHEAD / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			head := readResponseHead(t, br)
+			assert.Equal(t, "1000", head.Headers["content-length"])
+			assert.Equal(t, "keep-alive", head.Headers["connection"])
+		}
+	})
+
+	t.Run("Error responses have no body", func(t *testing.T) {
+		client, _ := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			if req.RequestLine.RequestTarget == "/missing" {
+				return &HandlerError{StatusCode: response.StatusNotFound, Message: "not here\n"}
+			}
+			return echoTargetHandler(w, req)
+		})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("HEAD /missing HTTP/1.1\r\n\r\nGET /found HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		head := readResponseHead(t, br)
+		assert.Equal(t, "HTTP/1.1 404 Not Found", head.StatusLine)
+		assert.Equal(t, "9", head.Headers["content-length"])
+		assert.Equal(t, "you asked for /found", readResponse(t, br).Body)
+	})
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	// slowHandler blocks requests for /slow until release is closed.
+	newSlowHandler := func(started chan<- struct{}, release <-chan struct{}) Handler {
+		return func(w ResponseWriter, req *request.Request) *HandlerError {
+			if req.RequestLine.RequestTarget == "/slow" {
+				close(started)
+				<-release
+			}
+			fmt.Fprint(w, "done")
+			return nil
+		}
+	}
+
+	t.Run("Drains active requests and closes idle connections", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		s, err := ServeWithConfig(0, newSlowHandler(started, release), Config{})
+		require.NoError(t, err)
+		addr := s.listener.Addr().String()
+
+		// An idle keep-alive connection that has already been served once.
+		idle, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer idle.Close()
+		idleReader := bufio.NewReader(idle)
+		_, err = idle.Write([]byte("GET /fast HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "keep-alive", readResponse(t, idleReader).Headers["connection"])
+
+		// A connection with a request in progress.
+		busy, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer busy.Close()
+		_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		<-started
+
+		shutdownErr := make(chan error, 1)
+		go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+		// The idle connection is closed right away...
+		_, err = idleReader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+		// ...and no new connections are accepted.
+		require.Eventually(t, func() bool {
+			c, err := net.Dial("tcp", addr)
+			if err == nil {
+				c.Close()
+			}
+			return err != nil
+		}, time.Second, 10*time.Millisecond)
+
+		// The busy request still gets its full response, then the connection closes.
+		select {
+		case <-shutdownErr:
+			t.Fatal("Shutdown returned while a request was in progress")
+		default:
+		}
+		close(release)
+		resp := readResponse(t, bufio.NewReader(busy))
+		assert.Equal(t, "done", resp.Body)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		require.NoError(t, <-shutdownErr)
+	})
+
+	t.Run("Connection accepted during shutdown is not served", func(t *testing.T) {
+		// A connection that Accept returned just before the listener was
+		// closed, but that wasn't tracked in time for Shutdown to see it.
+		s := &Server{handler: echoTargetHandler}
+		s.isClosed.Store(true)
+		client, serverConn := net.Pipe()
+		defer client.Close()
+
+		assert.Nil(t, s.trackConn(serverConn))
+		assert.Empty(t, s.conns)
+		_, err := client.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Context expiry closes stragglers", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		defer close(release)
+		s, err := ServeWithConfig(0, newSlowHandler(started, release), Config{})
+		require.NoError(t, err)
+
+		busy, err := net.Dial("tcp", s.listener.Addr().String())
+		require.NoError(t, err)
+		defer busy.Close()
+		_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+		_, err = busy.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	config := Config{
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		ReadTimeout:       200 * time.Millisecond,
+		WriteTimeout:      200 * time.Millisecond,
+		IdleTimeout:       100 * time.Millisecond,
+	}
+
+	t.Run("Idle connection is closed without a response", func(t *testing.T) {
+		client, done := startConnWithConfig(t, echoTargetHandler, config)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		readResponse(t, br)
+
+		waitClosed(t, done)
+		_, err = br.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Slow headers get 408", func(t *testing.T) {
+		client, done := startConnWithConfig(t, echoTargetHandler, config)
+		br := bufio.NewReader(client)
+
+		// Trickle the request out slower than ReadHeaderTimeout allows.
+		go func() {
+			for _, b := range []byte("GET / HTTP/1.1\r\nHost: localhost\r\n") {
+				if _, err := client.Write([]byte{b}); err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}()
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 408 Request Timeout", resp.StatusLine)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		waitClosed(t, done)
+	})
+
+	t.Run("Stalled headers time out without a header or read timeout", func(t *testing.T) {
+		// With neither ReadHeaderTimeout nor ReadTimeout set, the headers
+		// get IdleTimeout; the zero Config falls back to the default one.
+		assert.Equal(t, defaultIdleTimeout, Config{}.headerTimeout())
+
+		client, done := startConnWithConfig(t, echoTargetHandler, Config{IdleTimeout: 100 * time.Millisecond})
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("G"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 408 Request Timeout", resp.StatusLine)
+		waitClosed(t, done)
+	})
+
+	t.Run("Slow body gets 408", func(t *testing.T) {
+		client, done := startConnWithConfig(t, echoTargetHandler, config)
+		br := bufio.NewReader(client)
+
+		_, err := client.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc"))
+		require.NoError(t, err)
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 408 Request Timeout", resp.StatusLine)
+		waitClosed(t, done)
+	})
+
+	t.Run("Client that doesn't read the response is dropped", func(t *testing.T) {
+		client, done := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			fmt.Fprint(w, strings.Repeat("x", 1024))
+			return nil
+		}, config)
+
+		_, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		waitClosed(t, done)
+	})
+}
+
+func TestServer_Limits(t *testing.T) {
+	config := Config{Limits: request.Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderBytes:      128,
+		MaxHeaderCount:      5,
+		MaxBodyBytes:        16,
+	}}
+	cases := []rejectCase{
+		{
+			name:       "Long target",
+			request:    "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n",
+			statusLine: "HTTP/1.1 414 URI Too Long",
+		},
+		{
+			name:       "Huge header",
+			request:    "GET / HTTP/1.1\r\nCookie: " + strings.Repeat("c", 200) + "\r\n\r\n",
+			statusLine: "HTTP/1.1 431 Request Header Fields Too Large",
+		},
+		{
+			name:       "Large body",
+			request:    "POST / HTTP/1.1\r\nContent-Length: 17\r\n\r\n",
+			statusLine: "HTTP/1.1 413 Content Too Large",
+		},
+	}
+	runRejectCases(t, config, cases)
+
+	t.Run("Unset fields keep their defaults", func(t *testing.T) {
+		config := Config{Limits: request.Limits{MaxBodyBytes: 100 << 20, MaxHeaderCount: -1}}
+		limits := config.limits()
+		assert.Equal(t, 100<<20, limits.MaxBodyBytes)
+		assert.Equal(t, request.DefaultLimits().MaxRequestLineBytes, limits.MaxRequestLineBytes)
+		assert.Equal(t, request.DefaultLimits().MaxHeaderBytes, limits.MaxHeaderBytes)
+		assert.Equal(t, -1, limits.MaxHeaderCount)
+	})
+
+	runRejectCases(t, Config{Limits: request.Limits{MaxBodyBytes: 100 << 20}}, []rejectCase{
+		{
+			name:       "Raising the body limit keeps the request line limit",
+			request:    "GET /" + strings.Repeat("a", 10*1024) + " HTTP/1.1\r\n\r\n",
+			statusLine: "HTTP/1.1 414 URI Too Long",
+		},
+	})
+
+	t.Run("-1 removes a limit", func(t *testing.T) {
+		client, _ := startConnWithConfig(t, echoTargetHandler, Config{Limits: request.Limits{MaxHeaderCount: -1}})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n" + strings.Repeat("X-A: b\r\n", 200) + "\r\n"))
+		assert.Equal(t, "HTTP/1.1 200 OK", readResponse(t, br).StatusLine)
+	})
+}
+
+func TestServer_ParseErrors(t *testing.T) {
+	runRejectCases(t, Config{}, []rejectCase{
+		{
+			name:       "Malformed request line",
+			request:    "GET /\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:       "Bad header character",
+			request:    "GET / HTTP/1.1\r\nH@st: x\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:       "Unknown method",
+			request:    "BREW /pot HTTP/1.1\r\n\r\n",
+			statusLine: "HTTP/1.1 501 Not Implemented",
+		},
+		{
+			name:       "Unsupported version",
+			request:    "GET / HTTP/2.0\r\n\r\n",
+			statusLine: "HTTP/1.1 505 HTTP Version Not Supported",
+		},
+		{
+			name:       "Unsupported transfer coding",
+			request:    "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+			statusLine: "HTTP/1.1 501 Not Implemented",
+		},
+		// The smuggled request after each of these must never be served:
+		// the connection closes after the 400.
+		{
+			name:       "Content-Length and Transfer-Encoding",
+			request:    "POST / HTTP/1.1\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /smuggled HTTP/1.1\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:       "Conflicting Content-Length",
+			request:    "POST / HTTP/1.1\r\nContent-Length: 0\r\nContent-Length: 24\r\n\r\nGET /smuggled HTTP/1.1\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:       "Obs-fold",
+			request:    "POST / HTTP/1.1\r\nX-Pad: a\r\n Transfer-Encoding: chunked\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:       "Bare LF",
+			request:    "GET / HTTP/1.1\r\nX-Pad: a\nContent-Length: 24\r\n\r\nGET /smuggled HTTP/1.1\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+	})
+}
+
+// rejectCase is a request the server should refuse before calling the
+// handler.
+type rejectCase struct {
+	name       string
+	request    string
+	statusLine string
+}
+
+// runRejectCases checks that each request gets the expected status and that
+// the connection is closed afterwards.
+func runRejectCases(t *testing.T, config Config, cases []rejectCase) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, done := startConnWithConfig(t, echoTargetHandler, config)
+			br := bufio.NewReader(client)
+
+			go client.Write([]byte(tc.request))
+			resp := readResponse(t, br)
+			assert.Equal(t, tc.statusLine, resp.StatusLine)
+			assert.Equal(t, "close", resp.Headers["connection"])
+			waitClosed(t, done)
+		})
+	}
+}
+
+func TestServer_Panics(t *testing.T) {
+	t.Run("Panic before the response is a 500", func(t *testing.T) {
+		reported := make(chan *Panic, 1)
+		config := Config{PanicHandler: func(p *Panic) { reported <- p }}
+		client, done := startConnWithConfig(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("X-Dropped", "yes")
+			fmt.Fprint(w, "partial")
+			panic("boom")
+		}, config)
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET /explode HTTP/1.1\r\n\r\n"))
+		resp := readResponse(t, br)
+		assert.Equal(t, "HTTP/1.1 500 Internal Server Error", resp.StatusLine)
+		assert.Equal(t, "close", resp.Headers["connection"])
+		assert.NotContains(t, resp.Headers, "x-dropped")
+		assert.Equal(t, "Internal Server Error\n", resp.Body)
+		waitClosed(t, done)
+
+		p := <-reported
+		assert.Equal(t, "boom", p.Value)
+		require.NotNil(t, p.Request)
+		assert.Equal(t, "/explode", p.Request.RequestLine.RequestTarget)
+		assert.Contains(t, string(p.Stack), "runHandler")
+		assert.Contains(t, p.String(), "Panic serving GET /explode HTTP/1.1")
+	})
+
+	t.Run("Panic after the headers were sent closes the connection", func(t *testing.T) {
+		client, done := startConn(t, func(w ResponseWriter, req *request.Request) *HandlerError {
+			w.Header().Set("Transfer-Encoding", "chunked")
+			fmt.Fprint(w, "partial")
+			panic("boom")
+		})
+		br := bufio.NewReader(client)
+
+		go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		statusLine, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+		// The chunked body never gets its terminating chunk.
+		_, err = io.ReadAll(br)
+		require.NoError(t, err)
+		waitClosed(t, done)
+	})
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the remaining
+// connections have finished.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown stops the server without interrupting requests in progress. It
+// stops accepting connections, closes connections that are waiting for a
+// request, and then waits for the others to finish their current response,
+// after which they are closed instead of being kept alive.
+//
+// If ctx expires first, the remaining connections are closed forcibly and
+// ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.isClosed.Store(true)
+	err := s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeConns(true) == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.closeConns(false)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -10,7 +10,7 @@ import (
 func main() {
 	fmt.Println("╔════════════════════════════════════════════════════════╗")
 	fmt.Println("║     HTTP Request Debugger - Testing Various Formats   ║")
-	fmt.Println("╚════════════════════════════════════════════════════════╝\n")
+	fmt.Print("╚════════════════════════════════════════════════════════╝\n\n")
 
 	testCases := []struct {
 		name    string
@@ -58,6 +58,24 @@ func main() {
 				"Host: localhost:42069\r\n" +
 				"\r\n",
 		},
+		{
+			name: "Absolute URI routed by its path (expect 400 from /yourproblem)",
+			request: "GET http://localhost:42069/yourproblem?from=proxy HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"\r\n",
+		},
+		{
+			name: "HEAD request (expect GET's headers and no body)",
+			request: "HEAD / HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"\r\n",
+		},
+		{
+			name: "OPTIONS * (expect 204 with an Allow header)",
+			request: "OPTIONS * HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"\r\n",
+		},
 	}
 
 	for i, tc := range testCases {
@@ -67,7 +85,7 @@ func main() {
 		conn, err := net.DialTimeout("tcp", "localhost:42069", 2*time.Second)
 		if err != nil {
 			fmt.Printf("├─ ❌ Connection failed: %v\n", err)
-			fmt.Println("└─ FAILED\n")
+			fmt.Print("└─ FAILED\n\n")
 			continue
 		}
 
@@ -79,7 +97,7 @@ func main() {
 		if err != nil {
 			fmt.Printf("├─ ❌ Write failed: %v\n", err)
 			conn.Close()
-			fmt.Println("└─ FAILED\n")
+			fmt.Print("└─ FAILED\n\n")
 			continue
 		}
 		fmt.Printf("├─ Wrote %d bytes successfully\n", n)
@@ -125,10 +143,10 @@ func main() {
 				}
 			}
 			fmt.Println("│  └────────────────────────────────────")
-			fmt.Println("└─ ✅ SUCCESS\n")
+			fmt.Print("└─ ✅ SUCCESS\n\n")
 		} else {
 			fmt.Println("├─ ⚠️  No response received (server may have closed connection)")
-			fmt.Println("└─ FAILED\n")
+			fmt.Print("└─ FAILED\n\n")
 		}
 
 		time.Sleep(200 * time.Millisecond)